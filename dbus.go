@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// Synchronous method call on the session bus. The connection is a singleton shared by all callers,
+// and respects $DBUS_SESSION_BUS_ADDRESS.
+func dbusCall(busName, objectPath, interfaceName, methodName string, parameters *glib.Variant, replyType string) (*glib.Variant, error) {
+	conn, err := gio.BusGetSync(context.Background(), gio.BusTypeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var typ *glib.VariantType
+	if replyType != "" {
+		typ = glib.NewVariantType(replyType)
+	}
+	return conn.CallSync(context.Background(), busName, objectPath, interfaceName, methodName, parameters, typ,
+		gio.DBusCallFlagsNone, -1)
+}
+
+// Asynchronous version of dbusCall; w/o the reply type check
+func dbusCallAsync(busName, objectPath, interfaceName, methodName string, parameters *glib.Variant,
+	callback func(*glib.Variant, error)) {
+	conn, err := gio.BusGetSync(context.Background(), gio.BusTypeSession)
+	if err != nil {
+		callback(nil, err)
+		return
+	}
+
+	conn.Call(context.Background(), busName, objectPath, interfaceName, methodName, parameters, nil,
+		gio.DBusCallFlagsNone, -1, func(res gio.AsyncResulter) {
+			callback(conn.CallFinish(res))
+		})
+}
+
+// Property of the (sv) type, as used in systemd unit properties
+func dbusProperty(name string, value *glib.Variant) *glib.Variant {
+	return glib.NewVariantTuple([]*glib.Variant{glib.NewVariantString(name), glib.NewVariantVariant(value)})
+}
+
+// Asks systemd to create a transient scope unit containing the already running process
+func startTransientScope(unit, description string, pid int) error {
+	properties := glib.NewVariantArray(glib.NewVariantType("(sv)"), []*glib.Variant{
+		dbusProperty("Description", glib.NewVariantString(description)),
+		dbusProperty("Slice", glib.NewVariantString("app.slice")),
+		dbusProperty("CollectMode", glib.NewVariantString("inactive-or-failed")),
+		dbusProperty("PIDs", glib.NewVariantArray(glib.NewVariantType("u"),
+			[]*glib.Variant{glib.NewVariantUint32(uint32(pid))})),
+	})
+	aux := glib.NewVariantArray(glib.NewVariantType("(sa(sv))"), nil)
+
+	parameters := glib.NewVariantTuple([]*glib.Variant{
+		glib.NewVariantString(unit),
+		glib.NewVariantString("fail"),
+		properties,
+		aux,
+	})
+
+	_, err := dbusCall("org.freedesktop.systemd1", "/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager",
+		"StartTransientUnit", parameters, "(o)")
+	return err
+}
+
+/*
+Calls org.freedesktop.Application.Activate on the app's well-known bus name, that is the desktop file ID.
+Activating a cold app waits for its service to start, so the call is asynchronous, and the callback runs on
+the main loop.
+*/
+func activateApp(desktopID string, callback func(error)) {
+	// the dock's own XDG_ACTIVATION_TOKEN, if any, was single-use and meant for the dock's startup
+	parameters := glib.NewVariantTuple([]*glib.Variant{glib.NewVariantArray(glib.NewVariantType("{sv}"), nil)})
+
+	dbusCallAsync(desktopID, applicationObjectPath(desktopID), "org.freedesktop.Application", "Activate",
+		parameters, func(_ *glib.Variant, err error) {
+			callback(err)
+		})
+}
+
+// Asks the D-Bus activatable app to open URIs; asynchronous, as activateApp
+func openWithApp(desktopID string, uris []string, callback func(error)) {
+	parameters := glib.NewVariantTuple([]*glib.Variant{
+		glib.NewVariantStrv(uris),
+		glib.NewVariantArray(glib.NewVariantType("{sv}"), nil),
+	})
+
+	dbusCallAsync(desktopID, applicationObjectPath(desktopID), "org.freedesktop.Application", "Open",
+		parameters, func(_ *glib.Variant, err error) {
+			callback(err)
+		})
+}
+
+// The object path is derived from the bus name, as the Desktop Entry Specification says: "org.gnome.Maps"
+// becomes "/org/gnome/Maps", and "-" is replaced with "_".
+func applicationObjectPath(desktopID string) string {
+	return "/" + strings.ReplaceAll(strings.ReplaceAll(desktopID, ".", "/"), "-", "_")
+}
+
+// Sends a desktop notification through org.freedesktop.Notifications, w/o waiting for a daemon that may be missing
+func notify(summary, body string) {
+	parameters := glib.NewVariantTuple([]*glib.Variant{
+		glib.NewVariantString("nwg-dock-hyprland"),
+		glib.NewVariantUint32(0),
+		glib.NewVariantString("dialog-error"),
+		glib.NewVariantString(summary),
+		glib.NewVariantString(body),
+		glib.NewVariantStrv(nil),
+		glib.NewVariantArray(glib.NewVariantType("{sv}"), nil),
+		glib.NewVariantInt32(-1),
+	})
+
+	dbusCallAsync("org.freedesktop.Notifications", "/org/freedesktop/Notifications",
+		"org.freedesktop.Notifications", "Notify", parameters, func(_ *glib.Variant, err error) {
+			if err != nil {
+				log.Debugf("Couldn't send notification: %s", err)
+			}
+		})
+}
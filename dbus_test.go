@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+)
+
+const stubAppID = "org.example.DockStub"
+
+// Runs on a private session bus: a stub owning the app's name answers Activate; w/o it, the Exec command runs.
+func TestLaunchDBusActivatable(t *testing.T) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	bus := gio.NewTestDBus(gio.TestDBusNone)
+	bus.Up()
+	defer bus.Down()
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "exec-started")
+	desktopFile := fmt.Sprintf("[Desktop Entry]\nType=Application\nName=Stub\nExec=touch %s\nDBusActivatable=true\n",
+		marker)
+	if err := os.WriteFile(filepath.Join(dir, stubAppID+".desktop"), []byte(desktopFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	appDirs = []string{dir}
+
+	activated := make(chan string, 1)
+	stub := startStubApp(t, bus.BusAddress(), activated)
+
+	t.Run("activate", func(t *testing.T) {
+		launch(stubAppID)
+		var path string
+		waitFor(t, func() bool {
+			select {
+			case path = <-activated:
+				return true
+			default:
+				return false
+			}
+		})
+		if path != applicationObjectPath(stubAppID) {
+			t.Errorf("Activate called on %s, expected %s", path, applicationObjectPath(stubAppID))
+		}
+		if pathExists(marker) {
+			t.Error("Exec command run despite the successful activation")
+		}
+	})
+
+	t.Run("exec fallback", func(t *testing.T) {
+		if err := stub.CloseSync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		launch(stubAppID)
+		waitFor(t, func() bool { return pathExists(marker) })
+	})
+}
+
+// Owns the stub app's name on the bus, and replies to Activate w/ no error
+func startStubApp(t *testing.T, address string, activated chan<- string) *gio.DBusConnection {
+	conn, err := gio.NewDBusConnectionForAddressSync(context.Background(), address,
+		gio.DBusConnectionFlagsAuthenticationClient|gio.DBusConnectionFlagsMessageBusConnection, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.AddFilter(func(c *gio.DBusConnection, message *gio.DBusMessage, incoming bool) *gio.DBusMessage {
+		if incoming && message.MessageType() == gio.DBusMessageTypeMethodCall &&
+			message.Interface() == "org.freedesktop.Application" && message.Member() == "Activate" {
+			c.SendMessage(message.NewMethodReply(), gio.DBusSendMessageFlagsNone)
+			activated <- message.Path()
+			return nil
+		}
+		return message
+	})
+
+	// 4 - DBUS_NAME_FLAG_DO_NOT_QUEUE
+	_, err = conn.CallSync(context.Background(), "org.freedesktop.DBus", "/org/freedesktop/DBus",
+		"org.freedesktop.DBus", "RequestName",
+		glib.NewVariantTuple([]*glib.Variant{glib.NewVariantString(stubAppID), glib.NewVariantUint32(4)}),
+		glib.NewVariantType("(u)"), gio.DBusCallFlagsNone, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// Async D-Bus replies are delivered on the main loop, so we need to iterate it
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		glib.MainContextDefault().Iteration(false)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
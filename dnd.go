@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gdk/v3"
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	draggedItem    string // ID of the button being dragged, if any
+	rebuildPending bool   // buildMainBox called while dragging
+	pinnedBoxes    []pinnedBox
+	firstTaskBox   *gtk.Box
+)
+
+// Button of the pinned section, w/ the position of its pin in the pinned slice
+type pinnedBox struct {
+	box   *gtk.Box
+	index int
+}
+
+// Dock buttons are dragged as their .desktop files, so that .desktop files from elsewhere may be dropped the same way
+func dockItemTargets() []gtk.TargetEntry {
+	return []gtk.TargetEntry{*gtk.NewTargetEntry("text/uri-list", 0, 0)}
+}
+
+/*
+Dock buttons may be dragged along the dock to reorder pins, or into the pinned section to pin a running app.
+Dropping a pinned button off the dock unpins it.
+*/
+func setupDragSource(button *gtk.Button, ID string) {
+	button.DragSourceSet(gdk.Button1Mask, dockItemTargets(), gdk.ActionCopy)
+	if icon, err := getIcon(ID); err == nil {
+		if pixbuf, err := createPixbuf(icon, imgSizeScaled); err == nil {
+			button.DragSourceSetIconPixbuf(pixbuf)
+		}
+	}
+
+	button.ConnectDragBegin(func(context *gdk.DragContext) {
+		draggedItem = ID
+	})
+
+	button.ConnectDragDataGet(func(context *gdk.DragContext, data *gtk.SelectionData, info, time uint) {
+		data.SetURIs([]string{itemURI(ID)})
+	})
+
+	button.ConnectDragFailed(func(context *gdk.DragContext, result gtk.DragResult) bool {
+		if result == gtk.DragResultNoTarget && findPin(ID) != nil {
+			log.Infof("unpin %s (dragged off the dock)", ID)
+			unpinTask(ID)
+			return true
+		}
+		return false
+	})
+
+	button.ConnectDragEnd(func(context *gdk.DragContext) {
+		draggedItem = ""
+		// the button we were dragging would've been destroyed, so the rebuild had to wait
+		if rebuildPending {
+			rebuildPending = false
+			buildMainBox()
+		}
+	})
+}
+
+// Launchers and groups have no .desktop files, the URI just needs to be unique
+func itemURI(ID string) string {
+	if pin := findPin(ID); pin == nil || pin.isApp() {
+		if path := resolveApp(ID).Path; path != "" {
+			if uri, err := glib.FilenameToURI(path, ""); err == nil {
+				return uri
+			}
+		}
+	}
+	return "nwg-dock-hyprland:" + url.PathEscape(ID)
+}
+
+/*
+The dock window accepts its own buttons, and .desktop files dragged from the launcher, a file manager etc.
+The latter are pinned at the drop position.
+*/
+func setupDropTarget(win *gtk.Window) {
+	win.DragDestSet(gtk.DestDefaultAll, dockItemTargets(), gdk.ActionCopy)
+
+	win.ConnectDragDataReceived(func(context *gdk.DragContext, x, y int, data *gtk.SelectionData, info, time uint) {
+		dropOnDock(x, y, data)
+	})
+}
+
+// x, y in the dock window coordinates
+func dropOnDock(x, y int, data *gtk.SelectionData) {
+	index, inPinnedSection := pinDropIndex(x, y)
+	if draggedItem != "" {
+		dropItem(draggedItem, index, inPinnedSection)
+		return
+	}
+
+	for _, uri := range data.URIs() {
+		ID, err := desktopIDFromURI(uri)
+		if err != nil {
+			log.Warnf("Can't pin dropped item: %s", err)
+			continue
+		}
+		if findPin(ID) == nil {
+			log.Infof("pin %s at %v", ID, index)
+			pinTaskAt(ID, index)
+			index++
+		}
+	}
+	buildMainBox()
+}
+
+/*
+Files dropped on a button are opened w/ the app. Dock buttons and .desktop files are passed to the dock, as if dropped
+next to the button. Apps that don't declare MIME types, or don't take files in Exec, get the "drop-rejected" CSS class
+while a drag hovers them; the others get "drop-accepted".
+*/
+func setupButtonDrop(button *gtk.Button, ID string) {
+	button.DragDestSet(gtk.DestDefaultDrop, dockItemTargets(), gdk.ActionCopy)
+	accepts := acceptsFiles(ID)
+
+	button.ConnectDragMotion(func(context *gdk.DragContext, x, y int, time uint) bool {
+		gdk.DragStatus(context, gdk.ActionCopy, uint32(time))
+		if draggedItem == "" {
+			if accepts {
+				button.StyleContext().AddClass("drop-accepted")
+			} else {
+				button.StyleContext().AddClass("drop-rejected")
+			}
+		}
+		return true
+	})
+
+	button.ConnectDragLeave(func(context *gdk.DragContext, time uint) {
+		button.StyleContext().RemoveClass("drop-accepted")
+		button.StyleContext().RemoveClass("drop-rejected")
+	})
+
+	button.ConnectDragDataReceived(func(context *gdk.DragContext, x, y int, data *gtk.SelectionData, info, time uint) {
+		uris := data.URIs()
+		if draggedItem != "" || !slices.ContainsFunc(uris, func(uri string) bool {
+			return !strings.HasSuffix(uri, ".desktop")
+		}) {
+			a := button.Allocation()
+			dropOnDock(a.X()+x, a.Y()+y, data)
+			return
+		}
+		if !accepts {
+			log.Warnf("'%s' doesn't open files", ID)
+			return
+		}
+		openFiles(ID, uris)
+	})
+}
+
+// Dock buttons and .desktop files dropped on a group button are added to the group
+func setupGroupDrop(button *gtk.Button, groupID string) {
+	button.DragDestSet(gtk.DestDefaultAll, dockItemTargets(), gdk.ActionCopy)
+
+	button.ConnectDragDataReceived(func(context *gdk.DragContext, x, y int, data *gtk.SelectionData, info, time uint) {
+		if draggedItem == groupID {
+			a := button.Allocation()
+			dropOnDock(a.X()+x, a.Y()+y, data)
+			return
+		}
+		if draggedItem != "" {
+			addToGroup(groupID, draggedItem)
+			return
+		}
+		for _, uri := range data.URIs() {
+			ID, err := desktopIDFromURI(uri)
+			if err != nil {
+				log.Warnf("Can't add dropped item to group: %s", err)
+				continue
+			}
+			addToGroup(groupID, ID)
+		}
+	})
+}
+
+// Returns the Exec field code taking files; %f and %u take just one
+func fileFieldCode(ID string) string {
+	exec := rawExec(ID)
+	for _, code := range []string{"%F", "%U", "%f", "%u"} {
+		if strings.Contains(exec, code) {
+			return code
+		}
+	}
+	return ""
+}
+
+func mimeTypes(ID string) []string {
+	path := resolveApp(ID).Path
+	if path == "" {
+		return nil
+	}
+	return strings.FieldsFunc(getDesktopEntryValue(path, "MimeType"), func(r rune) bool { return r == ';' })
+}
+
+// Launchers, groups and custom commands have no MIME types, so they never accept files
+func acceptsFiles(ID string) bool {
+	if pin := findPin(ID); pin != nil && (!pin.isApp() || pin.Command != "") {
+		return false
+	}
+	return len(mimeTypes(ID)) > 0 && (fileFieldCode(ID) != "" || isDBusActivatable(ID))
+}
+
+// Local files are recognized by their names; other URIs by the scheme
+func canOpen(uri string, mimeTypes []string) bool {
+	var contentType string
+	if _, path, err := glib.FilenameFromURI(uri); err == nil {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			contentType = "inode/directory"
+		} else {
+			_, contentType = gio.ContentTypeGuess(path, nil)
+		}
+	} else if scheme, _, ok := strings.Cut(uri, ":"); ok {
+		contentType = "x-scheme-handler/" + scheme
+	}
+
+	for _, mimeType := range mimeTypes {
+		if gio.ContentTypeIsA(contentType, gio.ContentTypeFromMIMEType(mimeType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Opens dropped files w/ the app, over D-Bus if possible, or w/ the Exec field codes expanded
+func openFiles(ID string, uris []string) {
+	appMimeTypes := mimeTypes(ID)
+	uris = slices.DeleteFunc(uris, func(uri string) bool {
+		if !canOpen(uri, appMimeTypes) {
+			log.Warnf("'%s' can't open '%s'", ID, uri)
+			return true
+		}
+		return false
+	})
+	if len(uris) == 0 {
+		return
+	}
+
+	if hyprRules(ID) == "" && isDBusActivatable(ID) {
+		openWithApp(resolveAppID(ID), uris, func(err error) {
+			if err == nil {
+				log.Infof("Opened %s w/ '%s' over D-Bus", uris, ID)
+				return
+			}
+			log.Warnf("Opening files w/ '%s' over D-Bus failed, falling back to Exec: %s", ID, err)
+			openFilesWithExec(ID, uris)
+		})
+		if *autohide {
+			win.Hide()
+		}
+		return
+	}
+	openFilesWithExec(ID, uris)
+}
+
+func openFilesWithExec(ID string, uris []string) {
+	code := fileFieldCode(ID)
+	if code == "" {
+		log.Warnf("'%s' doesn't take files in Exec", ID)
+		return
+	}
+
+	var files []string
+	for _, uri := range uris {
+		file := uri
+		if code == "%f" || code == "%F" {
+			_, path, err := glib.FilenameFromURI(uri)
+			if err != nil {
+				log.Warnf("'%s' is not a local file", uri)
+				continue
+			}
+			file = path
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	// as the Desktop Entry Specification says, apps taking a single file are started once per file
+	if code == "%f" || code == "%u" {
+		for _, file := range files {
+			command, args := execWithFiles(ID, code, []string{file})
+			launchCommand(ID, command, args)
+		}
+	} else {
+		command, args := execWithFiles(ID, code, files)
+		launchCommand(ID, command, args)
+	}
+}
+
+/*
+Returns the Exec line up to the argument w/ the field code, and the arguments from there on, w/ the field code
+expanded in place, e.g. "flatpak run org.foo.Foo @@u %U @@" -> "flatpak run org.foo.Foo @@u", [files..., "@@"].
+The files stay separate arguments, as they may contain spaces.
+*/
+func execWithFiles(ID, code string, files []string) (string, []string) {
+	args := execArgs(rawExec(ID))
+	at := slices.IndexFunc(args, func(arg string) bool { return strings.Contains(arg, code) })
+	if at < 0 {
+		at = len(args)
+	}
+	return strings.Join(expandFieldCodes(args[:at], code, nil), " "), expandFieldCodes(args[at:], code, files)
+}
+
+// Splits the Exec value into arguments, w/ the double quotes and backslash escapes of the specification
+func execArgs(exec string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted, escaped := false, false, false
+	for _, r := range exec {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+/*
+Replaces the file field code w/ the files: %F and %U (standalone arguments only) w/ all of them, %f and %u w/ the first
+one. The other field codes (%i, %c, %k and the deprecated ones) are removed, and "%%" becomes "%".
+*/
+func expandFieldCodes(args []string, code string, files []string) []string {
+	var expanded []string
+	for _, arg := range args {
+		if arg == code && (code == "%F" || code == "%U") {
+			expanded = append(expanded, files...)
+			continue
+		}
+		var sb strings.Builder
+		removed := false
+		for i := 0; i < len(arg); i++ {
+			if arg[i] != '%' || i+1 == len(arg) {
+				sb.WriteByte(arg[i])
+				continue
+			}
+			i++
+			switch field := "%" + string(arg[i]); {
+			case field == "%%":
+				sb.WriteByte('%')
+			case field == code && len(files) > 0:
+				sb.WriteString(files[0])
+			default:
+				removed = true
+			}
+		}
+		// a field code alone expands to no argument at all
+		if removed && sb.Len() == 0 {
+			continue
+		}
+		expanded = append(expanded, sb.String())
+	}
+	return expanded
+}
+
+// Dropped .desktop files must come from the application directories, or we won't find them next time
+func desktopIDFromURI(uri string) (string, error) {
+	_, path, err := glib.FilenameFromURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(path, ".desktop") {
+		return "", fmt.Errorf("'%s' is not a .desktop file", path)
+	}
+	ID := strings.TrimSuffix(filepath.Base(path), ".desktop")
+	if exactDesktopFile(ID) == "" {
+		return "", fmt.Errorf("'%s' is not in any of the application directories", path)
+	}
+	return ID, nil
+}
+
+// Returns the position in the pinned slice to drop at, and if the drop point belongs to the pinned section
+func pinDropIndex(x, y int) (int, bool) {
+	coord := x
+	if vertical {
+		coord = y
+	}
+	start := func(box *gtk.Box) (int, int) {
+		a := box.Allocation()
+		if vertical {
+			return a.Y(), a.Height()
+		}
+		return a.X(), a.Width()
+	}
+
+	for _, pb := range pinnedBoxes {
+		from, size := start(pb.box)
+		if coord < from+size/2 {
+			return pb.index, true
+		}
+	}
+	if len(pinnedBoxes) > 0 {
+		from, size := start(pinnedBoxes[len(pinnedBoxes)-1].box)
+		return len(pinned), coord < from+size
+	}
+	if firstTaskBox != nil {
+		from, size := start(firstTaskBox)
+		return len(pinned), coord < from+size/2
+	}
+	return len(pinned), true
+}
+
+func dropItem(ID string, index int, inPinnedSection bool) {
+	from := slices.IndexFunc(pinned, func(pin pinnedItem) bool { return pin.refersTo(ID) })
+	if from < 0 {
+		if !inPinnedSection {
+			return
+		}
+		log.Infof("pin %s at %v", ID, index)
+		pinTaskAt(ID, index)
+	} else {
+		if index == from || index == from+1 {
+			return
+		}
+		item := pinned[from]
+		pinned = slices.Delete(pinned, from, from+1)
+		if index > from {
+			index--
+		}
+		pinned = slices.Insert(pinned, index, item)
+		savePinned()
+	}
+	buildMainBox()
+}
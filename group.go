@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gdk/v3"
+	"github.com/diamondburned/gotk4/pkg/gdkpixbuf/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Pin groups (stacks) show several apps behind one button, that opens a popover grid of them
+
+func (pin *pinnedItem) isGroup() bool {
+	return pin.Type == "group"
+}
+
+// Up to 4 member icons, 2 x 2
+func groupImage(group *pinnedItem, size int) *gtk.Image {
+	if group.Icon != "" || len(group.Members) == 0 {
+		icon, _ := getIcon(group.ID)
+		pixbuf, err := createPixbuf(icon, size)
+		if err != nil {
+			return nil
+		}
+		return gtk.NewImageFromPixbuf(pixbuf)
+	}
+
+	composite := gdkpixbuf.NewPixbuf(gdkpixbuf.ColorspaceRGB, true, 8, size, size)
+	composite.Fill(0)
+	half := size / 2
+	for i, member := range group.Members[:min(len(group.Members), 4)] {
+		icon, err := getIcon(member)
+		if err != nil {
+			icon = member
+		}
+		pixbuf, err := createPixbuf(icon, half)
+		if err != nil {
+			continue
+		}
+		x, y := i%2*half, i/2*half
+		pixbuf.Composite(composite, x, y, half, half, float64(x), float64(y), 1, 1, gdkpixbuf.InterpBilinear, 255)
+	}
+	return gtk.NewImageFromPixbuf(composite)
+}
+
+func showGroup(groupID string, button *gtk.Button) {
+	group := findPin(groupID)
+	if group == nil {
+		return
+	}
+
+	popover := gtk.NewPopover(button)
+	switch *position {
+	case "top":
+		popover.SetPosition(gtk.PosBottom)
+	case "left":
+		popover.SetPosition(gtk.PosRight)
+	case "right":
+		popover.SetPosition(gtk.PosLeft)
+	default:
+		popover.SetPosition(gtk.PosTop)
+	}
+
+	grid := gtk.NewGrid()
+	grid.SetObjectProperty("name", "group")
+	columns := max(int(math.Ceil(math.Sqrt(float64(len(group.Members))))), 1)
+	for i, member := range group.Members {
+		grid.Attach(groupMemberButton(groupID, member, popover), i%columns, i/columns, 1, 1)
+	}
+	popover.Add(grid)
+	grid.ShowAll()
+	popover.Popup()
+}
+
+// Focuses the member's window if any, launches the member otherwise
+func groupMemberButton(groupID, member string, popover *gtk.Popover) *gtk.Button {
+	button := gtk.NewButton()
+	image, _ := createImage(member, *imgSize)
+	if image == nil {
+		image = gtk.NewImageFromIconName("image-missing", int(gtk.IconSizeDialog))
+	}
+	button.SetImage(image)
+	button.SetAlwaysShowImage(true)
+	button.SetTooltipText(getName(member))
+
+	button.Connect("button-release-event", func(btn *gtk.Button, e *gdk.Event) bool {
+		btnEvent := e.AsButton()
+		if btnEvent.Button() == 1 || btnEvent.Button() == 2 {
+			popover.Popdown()
+			if instances := taskInstances(member); len(instances) > 0 && btnEvent.Button() == 1 {
+				cmd := fmt.Sprintf("dispatch focuswindow address:%s", instances[0].Address)
+				reply, _ := hyprctl(cmd)
+				log.Debugf("%s -> %s", cmd, reply)
+			} else {
+				launchWithFeedback(member, nil)
+			}
+			return true
+		} else if btnEvent.Button() == 3 {
+			menu := gtk.NewMenu()
+			menuItem := gtk.NewMenuItemWithLabel("Remove from group")
+			menuItem.Connect("activate", func() {
+				popover.Popdown()
+				removeFromGroup(groupID, member)
+			})
+			menu.Append(menuItem)
+			menu.ShowAll()
+			menu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
+			return true
+		}
+		return false
+	})
+
+	return button
+}
+
+func groupMenuContext(groupID string) gtk.Menu {
+	menu := gtk.NewMenu()
+
+	renameItem := gtk.NewMenuItemWithLabel("Rename…")
+	renameItem.Connect("activate", func() {
+		askText("Rename group", getName(groupID), func(name string) {
+			if group := findPin(groupID); group != nil {
+				group.Label = name
+				savePinned()
+				buildMainBox()
+			}
+		})
+	})
+	menu.Append(renameItem)
+
+	ungroupItem := gtk.NewMenuItemWithLabel("Ungroup")
+	ungroupItem.Connect("activate", func() {
+		ungroup(groupID)
+	})
+	menu.Append(ungroupItem)
+
+	unpinItem := gtk.NewMenuItemWithLabel("Unpin")
+	unpinItem.Connect("activate", func() {
+		unpinTask(groupID)
+	})
+	menu.Append(unpinItem)
+
+	menu.ShowAll()
+	return *menu
+}
+
+// "Add to group" submenu for app context menus
+func addToGroupMenuItem(ID string) *gtk.MenuItem {
+	menuItem := gtk.NewMenuItemWithLabel("Add to group")
+	submenu := gtk.NewMenu()
+	for _, pin := range pinned {
+		if !pin.isGroup() {
+			continue
+		}
+		groupID := pin.ID
+		groupItem := gtk.NewMenuItemWithLabel(getName(groupID))
+		groupItem.Connect("activate", func() {
+			addToGroup(groupID, ID)
+		})
+		submenu.Append(groupItem)
+	}
+	newItem := gtk.NewMenuItemWithLabel("New group…")
+	newItem.Connect("activate", func() {
+		askText("New group", "", func(name string) {
+			newGroup(name, ID)
+		})
+	})
+	submenu.Append(newItem)
+	menuItem.SetSubmenu(submenu)
+
+	return menuItem
+}
+
+// The dock's layer surface gets no keyboard focus, so we need a regular window to type in
+func askText(title, text string, callback func(string)) {
+	dialog := gtk.NewDialogWithFlags(title, nil, gtk.DialogModal)
+	entry := gtk.NewEntry()
+	entry.SetText(text)
+	entry.SetActivatesDefault(true)
+	dialog.ContentArea().PackStart(entry, true, true, 6)
+	dialog.AddButton("Cancel", int(gtk.ResponseCancel))
+	dialog.AddButton("OK", int(gtk.ResponseOK))
+	dialog.SetDefaultResponse(int(gtk.ResponseOK))
+
+	dialog.ConnectResponse(func(responseID int) {
+		if value := strings.TrimSpace(entry.Text()); responseID == int(gtk.ResponseOK) && value != "" {
+			callback(value)
+		}
+		dialog.Destroy()
+	})
+	dialog.ShowAll()
+}
+
+var nonIDChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Creates a group w/ the app, in place of the app's pin if any
+func newGroup(name, ID string) {
+	base := "group-" + strings.Trim(nonIDChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	groupID := base
+	for n := 2; findPin(groupID) != nil; n++ {
+		groupID = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	index := slices.IndexFunc(pinned, func(pin pinnedItem) bool { return pin.isApp() && pin.refersTo(ID) })
+	if index < 0 {
+		index = len(pinned)
+	}
+	pinned = slices.Insert(pinned, index, pinnedItem{ID: groupID, Type: "group", Label: name})
+	log.Infof("New group '%s'", groupID)
+	addToGroup(groupID, ID)
+}
+
+// Moves the app into the group; its separate pin, if any, is removed
+func addToGroup(groupID, ID string) {
+	group := findPin(groupID)
+	if group == nil || !group.isGroup() {
+		return
+	}
+	if pin := findPin(ID); pin != nil && !pin.isApp() {
+		log.Warnf("Only apps may be added to groups, '%s' is a %s", ID, pin.Type)
+		return
+	}
+	ID = resolveAppID(ID)
+	if !slices.ContainsFunc(group.Members, func(member string) bool { return sameApp(member, ID) }) {
+		group.Members = append(group.Members, ID)
+	}
+	pinned = slices.DeleteFunc(pinned, func(pin pinnedItem) bool {
+		return pin.isApp() && pin.refersTo(ID)
+	})
+	savePinned()
+	buildMainBox()
+}
+
+func removeFromGroup(groupID, member string) {
+	if group := findPin(groupID); group != nil {
+		group.Members = slices.DeleteFunc(group.Members, func(m string) bool { return m == member })
+		savePinned()
+		buildMainBox()
+	}
+}
+
+// Replaces the group w/ its members' pins
+func ungroup(groupID string) {
+	index := slices.IndexFunc(pinned, func(pin pinnedItem) bool { return pin.isGroup() && pin.ID == groupID })
+	if index < 0 {
+		return
+	}
+	var members []pinnedItem
+	for _, member := range pinned[index].Members {
+		if findPin(member) == nil {
+			members = append(members, pinnedItem{ID: member})
+		}
+	}
+	pinned = slices.Replace(pinned, index, index+1, members...)
+	savePinned()
+	buildMainBox()
+}
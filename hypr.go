@@ -100,6 +100,16 @@ func listMonitors() error {
 	return err
 }
 
+func listWorkspaces() ([]workspace, error) {
+	var workspaces []workspace
+	reply, err := hyprctl("j/workspaces")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(reply), &workspaces)
+	return workspaces, err
+}
+
 func listClients() error {
 	reply, err := hyprctl("j/clients")
 	if err != nil {
@@ -111,6 +121,19 @@ func listClients() error {
 	return err
 }
 
+func getActiveWorkspace() (*workspace, error) {
+	var activeWorkspace workspace
+	reply, err := hyprctl("j/activeworkspace")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(reply), &activeWorkspace)
+	if err == nil {
+		return &activeWorkspace, nil
+	}
+	return nil, err
+}
+
 func getActiveWindow() (*client, error) {
 	var activeWindow client
 	reply, err := hyprctl("j/activewindow")
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Every lookup (pinning, grouping, icons, names, launching) goes through the same resolver, so that an app known by
+different class spellings, e.g. "Firefox" pinned and "firefox" running, appears as exactly one button.
+The canonical app ID is the desktop file ID, or the class itself, if no matching .desktop file was found.
+*/
+type appIdentity struct {
+	ID      string
+	Path    string // .desktop file, if found
+	Guessed bool   // the .desktop file only guessed from the class, so the ID stays the class
+}
+
+var (
+	identityCache       = make(map[string]appIdentity) // class -> resolved identity
+	clientIdentityCache = make(map[string]appIdentity) // "pid:class:initialClass:initialTitle" -> resolved identity
+	desktopFiles        []string
+	desktopExecs        map[string]string       // .desktop file path -> binary from TryExec / Exec
+	desktopNames        map[string][]string     // lowercase Name -> .desktop file paths
+	classlessIDs        = make(map[string]bool) // titles and "pid-N" made up for windows w/o class, never resolved
+	appDirsMonitors     []gio.FileMonitorrer    // referenced, or they'd be garbage collected
+	appDirsReload       glib.SourceHandle
+)
+
+func resolveApp(class string) appIdentity {
+	class = strings.TrimSpace(class)
+	if classlessIDs[class] {
+		return appIdentity{ID: class}
+	}
+	if identity, ok := identityCache[class]; ok {
+		return identity
+	}
+
+	identity := appIdentity{ID: class}
+	if rule := matchAppRule(class, class, ""); rule != nil {
+		identity = identityFromRule(rule, class)
+	} else if steamGame, ok := steamGameIdentity(class); ok {
+		identity = steamGame
+	} else if class != "" && !strings.HasPrefix(class, "/") { // skip icon paths given instead of names
+		if p := exactDesktopFile(class); p != "" {
+			identity = identityFromPath(p)
+		} else if p := searchDesktopDirs(class); p != "" {
+			/* Some apps' class varies from their .desktop file name, e.g. 'gimp-2.9.9' or 'pamac-manager'.
+			   A guess may fit several classes though, e.g. "foo" and "foot", so it mustn't merge them */
+			identity = appIdentity{ID: class, Path: p, Guessed: true}
+		}
+	}
+	identityCache[class] = identity
+
+	return identity
+}
+
+func identityFromPath(path string) appIdentity {
+	identity := appIdentity{ID: strings.TrimSuffix(filepath.Base(path), ".desktop"), Path: path}
+	// the file may come from outside appDirs, let further lookups by ID find it
+	if _, ok := identityCache[identity.ID]; !ok {
+		identityCache[identity.ID] = identity
+	}
+	return identity
+}
+
+// The rule's desktop-id, if given, is the app ID; otherwise the class is, w/ the rule's overrides applied to it
+func identityFromRule(rule *appRule, class string) appIdentity {
+	identity := appIdentity{ID: class}
+	if rule.DesktopID != "" {
+		identity.ID = strings.TrimSuffix(rule.DesktopID, ".desktop")
+		if p := exactDesktopFile(identity.ID); p != "" {
+			identity = identityFromPath(p)
+		}
+	}
+	if rule.Icon != "" || rule.Name != "" || rule.Exec != "" {
+		appOverrides[identity.ID] = rule
+	}
+	return identity
+}
+
+func resolveAppID(class string) string {
+	return resolveApp(class).ID
+}
+
+/*
+Returns the canonical app ID of the window. Pins' patterns and user-defined rules go first, and aren't cached.
+Steam games and web apps run inside Steam's or the browser's process (and sandbox), so they're next. Sandboxed apps
+know their IDs for sure. Otherwise exact class -> .desktop file name match is the most reliable, then we ask
+the process, and only then we guess from the class.
+*/
+func clientAppID(c client) string {
+	class := c.Class
+	if class == "" {
+		class = c.InitialClass
+	}
+
+	// pins may be edited any time, so their patterns are matched before the cache
+	if pin := matchPin(c); pin != nil {
+		return resolveAppID(pin.ID)
+	}
+	// so are the rules, as titles change, and windows of one process may have different ones
+	if rule := matchAppRule(c.Class, c.InitialClass, c.Title); rule != nil {
+		if class == "" {
+			return identityFromRule(rule, c.Title).ID
+		}
+		return identityFromRule(rule, class).ID
+	}
+
+	// PWAs share the browser's process and class, and differ in the initial title only
+	key := fmt.Sprintf("%d:%s:%s:%s", c.Pid, class, c.InitialClass, c.InitialTitle)
+	if identity, ok := clientIdentityCache[key]; ok {
+		return identity.ID
+	}
+
+	var identity appIdentity
+	if class == "" {
+		identity = classlessIdentity(c)
+	} else if steamGame, ok := steamGameIdentity(class); ok {
+		identity = steamGame
+	} else if p := desktopFileFromWebApp(c); p != "" {
+		log.Debugf("'%s' resolved as a web app: %s", class, p)
+		identity = identityFromPath(p)
+	} else if p := desktopFileFromSandbox(c.Pid); p != "" {
+		log.Debugf("'%s' resolved from sandbox info of process %v: %s", class, c.Pid, p)
+		identity = identityFromPath(p)
+	} else if p := exactDesktopFile(class); p != "" {
+		identity = identityFromPath(p)
+	} else if p := desktopFileFromProcess(c.Pid); p != "" {
+		log.Debugf("'%s' resolved from process %v: %s", class, c.Pid, p)
+		identity = identityFromPath(p)
+	} else {
+		identity = resolveApp(class)
+	}
+	clientIdentityCache[key] = identity
+
+	return identity.ID
+}
+
+/*
+Some real windows (Xwayland, Java) never set the class. The process may still tell us the app; if not, the initial
+title is stable enough to group the windows by. As the last resort, each process is an app of its own.
+*/
+func classlessIdentity(c client) appIdentity {
+	if p := desktopFileFromProcess(c.Pid); p != "" {
+		return identityFromPath(p)
+	}
+
+	title := c.InitialTitle
+	if title == "" {
+		title = c.Title
+	}
+	if title != "" {
+		classlessIDs[title] = true
+		return appIdentity{ID: title}
+	}
+
+	ID := fmt.Sprintf("pid-%d", c.Pid)
+	classlessIDs[ID] = true
+	if comm, err := readTextFile(fmt.Sprintf("/proc/%d/comm", c.Pid)); err == nil {
+		appOverrides[ID] = &appRule{Name: strings.TrimSpace(comm), Exec: strings.TrimSpace(comm)}
+	}
+	return appIdentity{ID: ID}
+}
+
+// Guessed identities keep their classes as IDs, so they're never the same app as the .desktop file they guessed
+func sameApp(a, b string) bool {
+	return strings.EqualFold(resolveAppID(a), resolveAppID(b))
+}
+
+// Looks up the .desktop file of the process: in hints left by the launcher first, then by the executable
+func desktopFileFromProcess(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+
+	env := processEnviron(pid)
+	// GIO_LAUNCHED_DESKTOP_FILE is inherited by children, e.g. apps started from a terminal, so check the PID
+	if p := env["GIO_LAUNCHED_DESKTOP_FILE"]; p != "" && env["GIO_LAUNCHED_DESKTOP_FILE_PID"] == strconv.Itoa(pid) &&
+		pathExists(p) {
+		return p
+	}
+	if p := env["BAMF_DESKTOP_FILE_HINT"]; p != "" && pathExists(p) {
+		return p
+	}
+
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	var arg0 string
+	if cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		arg0, _, _ = strings.Cut(string(cmdline), "\x00")
+	}
+	if exe == "" && arg0 == "" {
+		return ""
+	}
+
+	// the binary must point at exactly one .desktop file, otherwise it's a guess as well
+	found := ""
+	for path, binary := range getDesktopExecs() {
+		if binaryMatches(binary, exe) || binaryMatches(binary, arg0) {
+			if found != "" {
+				return ""
+			}
+			found = path
+		}
+	}
+	return found
+}
+
+func binaryMatches(binary, path string) bool {
+	if binary == "" || path == "" {
+		return false
+	}
+	if strings.HasPrefix(binary, "/") {
+		return binary == path
+	}
+	return binary == filepath.Base(path)
+}
+
+func processEnviron(pid int) map[string]string {
+	env := make(map[string]string)
+	bytes, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return env
+	}
+	for _, item := range strings.Split(string(bytes), "\x00") {
+		k, v, found := strings.Cut(item, "=")
+		if found {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// Interpreters and wrappers don't tell anything about the app
+var genericBinaries = []string{"env", "sh", "bash", "python", "python3", "perl", "java", "electron", "flatpak",
+	"snap", "wine", "mono", "node", "gjs", "systemd-run", "uwsm", "app2unit"}
+
+// Returns paths to all the .desktop files; if the same ID occurs in many dirs, the first one takes precedence
+// Installing, removing or editing an app may change whatever we've resolved, misses included
+func watchAppDirs() {
+	for _, d := range appDirs {
+		if !pathExists(d) {
+			continue
+		}
+		monitor, err := gio.NewFileForPath(d).MonitorDirectory(context.Background(), gio.FileMonitorWatchMoves)
+		if err != nil {
+			log.Warnf("Can't watch %s: %s", d, err)
+			continue
+		}
+		appDirsMonitors = append(appDirsMonitors, monitor)
+
+		gio.BaseFileMonitor(monitor).ConnectChanged(func(file, otherFile gio.Filer, eventType gio.FileMonitorEvent) {
+			name := file.Basename()
+			switch eventType {
+			case gio.FileMonitorEventRenamed:
+				if !strings.HasSuffix(name, ".desktop") {
+					name = otherFile.Basename()
+				}
+			case gio.FileMonitorEventChangesDoneHint, gio.FileMonitorEventCreated, gio.FileMonitorEventDeleted,
+				gio.FileMonitorEventMovedIn, gio.FileMonitorEventMovedOut:
+			default:
+				return
+			}
+			if !strings.HasSuffix(name, ".desktop") {
+				return
+			}
+
+			// package managers install many files at once
+			if appDirsReload > 0 {
+				glib.SourceRemove(appDirsReload)
+			}
+			appDirsReload = glib.TimeoutAdd(500, func() bool {
+				appDirsReload = 0
+				log.Debug(".desktop files changed, resolving apps anew")
+				forgetDesktopFiles()
+				buildMainBox()
+				return false
+			})
+		})
+	}
+}
+
+func forgetDesktopFiles() {
+	identityCache = make(map[string]appIdentity)
+	clientIdentityCache = make(map[string]appIdentity)
+	desktopFiles = nil
+	desktopExecs = nil
+	desktopNames = nil
+}
+
+// Forgets the identities of the processes w/o windows anymore; pids get reused
+func pruneIdentityCache() {
+	hasWindows := func(pid string) bool {
+		return slices.ContainsFunc(clients, func(c client) bool { return strconv.Itoa(c.Pid) == pid })
+	}
+	for key := range clientIdentityCache {
+		if pid, _, _ := strings.Cut(key, ":"); !hasWindows(pid) {
+			delete(clientIdentityCache, key)
+		}
+	}
+	for ID := range classlessIDs {
+		if pid, ok := strings.CutPrefix(ID, "pid-"); ok && !hasWindows(pid) {
+			delete(classlessIDs, ID)
+			delete(appOverrides, ID)
+		}
+	}
+}
+
+func getDesktopFiles() []string {
+	if desktopFiles != nil {
+		return desktopFiles
+	}
+
+	desktopFiles = []string{}
+	var seen []string
+	for _, d := range appDirs {
+		files, _ := os.ReadDir(d)
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".desktop") || isIn(seen, f.Name()) {
+				continue
+			}
+			seen = append(seen, f.Name())
+			desktopFiles = append(desktopFiles, filepath.Join(d, f.Name()))
+		}
+	}
+	return desktopFiles
+}
+
+func getDesktopExecs() map[string]string {
+	if desktopExecs != nil {
+		return desktopExecs
+	}
+
+	desktopExecs = make(map[string]string)
+	for _, p := range getDesktopFiles() {
+		binary := execBinary(getDesktopEntryValue(p, "TryExec"))
+		if binary == "" {
+			binary = execBinary(getDesktopEntryValue(p, "Exec"))
+		}
+		if binary != "" && !isIn(genericBinaries, filepath.Base(binary)) {
+			desktopExecs[p] = binary
+		}
+	}
+	return desktopExecs
+}
+
+func getDesktopNames() map[string][]string {
+	if desktopNames != nil {
+		return desktopNames
+	}
+
+	desktopNames = make(map[string][]string)
+	for _, p := range getDesktopFiles() {
+		if name := strings.ToLower(getDesktopEntryValue(p, "Name")); name != "" {
+			desktopNames[name] = append(desktopNames[name], p)
+		}
+	}
+	return desktopNames
+}
+
+// Returns the program from the Exec line, w/o quotes and prepended env variables
+func execBinary(exec string) string {
+	for _, field := range strings.Fields(strings.ReplaceAll(exec, "\"", "")) {
+		if field == "env" || strings.Contains(field, "=") {
+			continue
+		}
+		return field
+	}
+	return ""
+}
+
+// Flatpak and Snap apps: the sandbox tells the app ID, that points at the exported .desktop file
+func desktopFileFromSandbox(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+
+	if appID := flatpakAppID(pid); appID != "" {
+		if p := exactDesktopFile(appID); p != "" {
+			return p
+		}
+		log.Debugf("No exported .desktop file found for Flatpak app '%s'", appID)
+		return ""
+	}
+
+	if name, app := snapApp(pid); name != "" {
+		// exported as <snap>_<app>.desktop, or at least <snap>_<something>.desktop
+		if app != "" {
+			if p := exactDesktopFile(fmt.Sprintf("%s_%s", name, app)); p != "" {
+				return p
+			}
+		}
+		for _, d := range appDirs {
+			matches, _ := filepath.Glob(filepath.Join(d, fmt.Sprintf("%s_*.desktop", name)))
+			if len(matches) > 0 {
+				return matches[0]
+			}
+		}
+		log.Debugf("No exported .desktop file found for snap '%s'", name)
+	}
+	return ""
+}
+
+// Returns the "name" key from the [Application] group of /.flatpak-info inside the sandbox
+func flatpakAppID(pid int) string {
+	lines, err := loadTextFile(fmt.Sprintf("/proc/%d/root/.flatpak-info", pid))
+	if err != nil {
+		return ""
+	}
+	group := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "[") {
+			group = line
+			continue
+		}
+		k, v, found := strings.Cut(line, "=")
+		if group == "[Application]" && found && strings.TrimSpace(k) == "name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+var snapScopeSuffix = regexp.MustCompile(`-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Snap apps run in the snap.<name>.<app>[-<uuid>].scope cgroup; SNAP_INSTANCE_NAME / SNAP_NAME is the fallback
+func snapApp(pid int) (string, string) {
+	lines, err := loadTextFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err == nil {
+		for _, line := range lines {
+			for _, segment := range strings.Split(line, "/") {
+				if !strings.HasPrefix(segment, "snap.") {
+					continue
+				}
+				fields := strings.Split(strings.TrimSuffix(strings.TrimSuffix(segment, ".scope"), ".service"), ".")
+				if len(fields) >= 3 {
+					// transient scopes carry a random UUID suffix
+					return fields[1], snapScopeSuffix.ReplaceAllString(fields[2], "")
+				}
+			}
+		}
+	}
+
+	env := processEnviron(pid)
+	if env["SNAP_INSTANCE_NAME"] != "" {
+		return env["SNAP_INSTANCE_NAME"], ""
+	}
+	return env["SNAP_NAME"], ""
+}
+
+var (
+	// Chromium-based browsers: <browser>-<app id>-<profile> on Wayland, crx_<app id> on XWayland
+	chromeAppClass = regexp.MustCompile(`^(?:[A-Za-z0-9.]+-)?([a-p]{32})-[^-]+$|^crx_([a-p]{32})$`)
+	// Firefox PWAs (firefoxpwa)
+	firefoxPWAClass = regexp.MustCompile(`^FFPWA-[0-9A-Z]{26}$`)
+)
+
+/*
+Chromium PWAs and Firefox profiles often share the browser's class, or have classes w/ the app ID inside.
+Let's look at the class, the initial class and the initial title to tell web apps from their browser.
+*/
+func desktopFileFromWebApp(c client) string {
+	for _, class := range []string{c.Class, c.InitialClass} {
+		if m := chromeAppClass.FindStringSubmatch(class); m != nil {
+			if p := exactDesktopFile(class); p != "" {
+				return p
+			}
+			appID := m[1] + m[2]
+			for _, p := range getDesktopFiles() {
+				if strings.Contains(filepath.Base(p), fmt.Sprintf("-%s-", appID)) ||
+					getDesktopEntryValue(p, "StartupWMClass") == fmt.Sprintf("crx_%s", appID) {
+					return p
+				}
+			}
+		}
+		if firefoxPWAClass.MatchString(class) {
+			if p := exactDesktopFile(class); p != "" {
+				return p
+			}
+		}
+	}
+
+	// Some web apps show up w/ the browser's class; their initial title is the app name then
+	if c.InitialTitle == "" {
+		return ""
+	}
+	browser := resolveApp(c.InitialClass).Path
+	if browser == "" || !strings.Contains(getDesktopEntryValue(browser, "Categories"), "WebBrowser") {
+		return ""
+	}
+	browserBinary := filepath.Base(execBinary(getDesktopEntryValue(browser, "Exec")))
+	for _, p := range getDesktopNames()[strings.ToLower(c.InitialTitle)] {
+		if p == browser {
+			continue
+		}
+		// web apps (Chromium) or profiles (Firefox) of the same browser
+		exec := getDesktopEntryValue(p, "Exec")
+		if strings.Contains(exec, browserBinary) && (strings.Contains(exec, "--app-id=") ||
+			strings.Contains(exec, "--app=") || strings.Contains(exec, " -P ") || strings.Contains(exec, "--profile")) {
+			return p
+		}
+	}
+	return ""
+}
@@ -39,6 +39,7 @@ const (
 
 var (
 	activeClient                       *client
+	activeWorkspace                    workspace // pins may be limited to some workspaces
 	appDirs                            []string
 	clients                            []client
 	configDirectory                    string
@@ -49,17 +50,19 @@ var (
 	ignoredWorkspaces                  []string
 	imgSizeScaled                      int
 	lastWinAddr                        string
+	launching                          = make(map[string]*pendingLaunch) // app ID -> launch awaiting the window
 	mainBox                            *gtk.Box
 	monitors                           []monitor
 	oldClients                         []client
 	outerOrientation, innerOrientation gtk.Orientation
-	pinned                             []string
+	pinned                             []pinnedItem
 	pinnedFile                         string
 	src                                glib.SourceHandle
 	widgetAnchor, menuAnchor           gdk.Gravity
 	win                                *gtk.Window
 	windowStateChannel                 chan WindowState = make(chan WindowState, 1)
 	classesToIgnore                    []string
+	closedAddresses                    []string // closewindow events may come before the ghost disappears from j/clients
 	mouseInsideDock                    bool
 	mouseInsideHotspot                 bool
 )
@@ -70,25 +73,32 @@ var autohide = flag.Bool("d", false, "auto-hiDe: show dock when hotspot hovered,
 var cssFileName = flag.String("s", "style.css", "Styling: css file name")
 var debug = flag.Bool("debug", false, "turn on debug messages")
 var displayVersion = flag.Bool("v", false, "display Version information")
+var exportPins = flag.String("export-pins", "", "EXPORT PINS to \"nwg-dock\", \"plank\" or \"gnome\", optionally followed by \":path\" (stdout by default), and exit")
 var exclusive = flag.Bool("x", false, "set eXclusive zone: move other windows aside; overrides the \"-l\" argument")
 var full = flag.Bool("f", false, "take Full screen width/height")
 var ignoreClasses = flag.String("g", "", "quote-delimited, space-separated class list to iGnore in the dock")
 var hotspotDelay = flag.Int64("hd", 20, "Hotspot Delay [ms]; the smaller, the faster mouse pointer needs to enter hotspot for the dock to appear; set 0 to disable")
 var hotspotLayer = flag.String("hl", "overlay", "Hotspot Layer \"overlay\" or \"top\"")
+var hyprExec = flag.Bool("hx", false, "launch apps with Hyprland's eXec dispatcher instead of as the dock's children")
 var ico = flag.String("ico", "", "alternative name or path for the launcher ICOn")
 var ignoreWorkspaces = flag.String("iw", "", "Ignore the running applications on these Workspaces based on the workspace's name or id, e.g. \"special,10\"")
 var imgSize = flag.Int("i", 48, "Icon size")
+var importPins = flag.String("import-pins", "", "IMPORT PINS from \"nwg-dock\", \"plank\", \"latte\" or \"gnome\", optionally followed by \":path\", and exit")
 var launcherCmd = flag.String("c", "nwg-drawer", "Command assigned to the launcher button")
 var launcherPos = flag.String("lp", "end", "Launcher button position, 'start' or 'end'")
+var launchTimeout = flag.Int("lt", 10, "Launch Timeout [s]: max time the clicked button stays in the launching state, until the app's window shows up; set 0 to disable")
 var layer = flag.String("l", "overlay", "Layer \"overlay\", \"top\" or \"bottom\"")
 var marginBottom = flag.Int("mb", 0, "Margin Bottom")
 var marginLeft = flag.Int("ml", 0, "Margin Left")
 var marginRight = flag.Int("mr", 0, "Margin Right")
 var marginTop = flag.Int("mt", 0, "Margin Top")
+var minimize = flag.Bool("mz", false, "MinimiZe the focused window on its button click, to the \"special:minimized\" workspace; click again to restore")
 var noLauncher = flag.Bool("nolauncher", false, "don't show the launcher button")
 var numWS = flag.Int64("w", 10, "number of Workspaces you use")
 var position = flag.String("p", "bottom", "Position: \"bottom\", \"top\" \"left\" or \"right\"")
+var pinSetName = flag.String("ps", "", "Pin Set to switch to, \"default\" for the default one; the running instance switches, if any")
 var resident = flag.Bool("r", false, "Leave the program resident, but w/o hotspot")
+var scope = flag.Bool("scope", false, "launch apps in systemd user SCOPE units: app-<desktop-id>-<random>.scope")
 var targetOutput = flag.String("o", "", "name of Output to display the dock on")
 var allowMultipleInstances = flag.Bool("m", false, "allow Multiple instances of the dock (skip lock file check)")
 
@@ -96,6 +106,10 @@ var vertical bool
 var alignmentBox *gtk.Box
 
 func buildMainBox() {
+	if draggedItem != "" {
+		rebuildPending = true
+		return
+	}
 	if mainBox != nil {
 		mainBox.Destroy()
 	}
@@ -109,16 +123,19 @@ func buildMainBox() {
 		alignmentBox.PackStart(mainBox, true, false, 0)
 	}
 
-	var err error
-	pinned, err = loadTextFile(pinnedFile)
-	if err != nil {
-		pinned = nil
-	}
+	loadPinned()
 
 	var allItems []string
 	for _, cntPin := range pinned {
-		if !isIn(allItems, cntPin) {
-			allItems = append(allItems, cntPin)
+		if cntPin.invalid || !cntPin.onWorkspace(activeWorkspace) {
+			continue
+		}
+		ID := cntPin.ID
+		if cntPin.isApp() {
+			ID = resolveAppID(ID)
+		}
+		if !isIn(allItems, ID) {
+			allItems = append(allItems, ID)
 		}
 	}
 
@@ -131,16 +148,31 @@ func buildMainBox() {
 		}
 	})
 
-	// delete the clients that are on ignored workspaces
+	// For some time after killing a client, it's still being returned by 'j/clients', however unmapped, and w/o
+	// the Class value. Let's filter the ghosts out.
+	// Closed addresses are forgotten only once 'j/clients' stops returning them.
+	closedAddresses = slices.DeleteFunc(closedAddresses, func(address string) bool {
+		return !slices.ContainsFunc(clients, func(cl client) bool { return cl.Address == address })
+	})
+	clients = slices.DeleteFunc(clients, isGhost)
+
+	pruneMinimized()
+	pruneIdentityCache()
+
+	// delete the clients that are on ignored workspaces; minimized ones must stay restorable
 	clients = slices.DeleteFunc(clients, func(cl client) bool {
+		if isMinimized(cl) {
+			return false
+		}
 		// only use the part in front of ":" if something like "special:scratch_term" is being used
 		clWorkspace, _, _ := strings.Cut(cl.Workspace.Name, ":")
 		return isIn(ignoredWorkspaces, strconv.Itoa(cl.Workspace.Id)) || isIn(ignoredWorkspaces, clWorkspace)
 	})
 
 	for _, cntTask := range clients {
-		if !isIn(allItems, cntTask.Class) && !strings.Contains(*launcherCmd, cntTask.Class) && cntTask.Class != "" {
-			allItems = append(allItems, cntTask.Class)
+		ID := clientAppID(cntTask)
+		if !isIn(allItems, ID) && (cntTask.Class == "" || !strings.Contains(*launcherCmd, cntTask.Class)) {
+			allItems = append(allItems, ID)
 		}
 	}
 
@@ -164,37 +196,48 @@ func buildMainBox() {
 		}
 	}
 
+	pinnedBoxes = nil
+	firstTaskBox = nil
 	var alreadyAdded []string
-	for _, pin := range pinned {
-		if !inTasks(pin) {
-			if !isIn(classesToIgnore, pin) {
+	for i, item := range pinned {
+		pin := item.ID
+		if item.invalid || !item.onWorkspace(activeWorkspace) {
+			continue
+		}
+		if !item.isApp() {
+			if !isIn(alreadyAdded, pin) {
 				button := pinnedButton(pin, position)
 				mainBox.PackStart(button, false, false, 0)
+				pinnedBoxes = append(pinnedBoxes, pinnedBox{button, i})
+				alreadyAdded = append(alreadyAdded, pin)
+			}
+		} else if !inTasks(pin) {
+			if isIn(alreadyAdded, resolveAppID(pin)) {
+				continue
+			}
+			if !isIgnored(pin) {
+				button := pinnedButton(pin, position)
+				mainBox.PackStart(button, false, false, 0)
+				pinnedBoxes = append(pinnedBoxes, pinnedBox{button, i})
+				alreadyAdded = append(alreadyAdded, resolveAppID(pin))
 			} else {
 				log.Debugf("Ignoring pin '%s'", pin)
 			}
 		} else {
 			instances := taskInstances(pin)
 			c := instances[0]
-			if !isIn(classesToIgnore, c.Class) {
-				if len(instances) == 1 {
-					button := taskButton(c, instances, position)
-					mainBox.PackStart(button, false, false, 0)
-					if c.Class == activeClient.Class && !*autohide {
-						button.SetObjectProperty("name", "active")
-					} else {
-						button.SetObjectProperty("name", "")
-					}
-				} else if !isIn(alreadyAdded, c.Class) {
+			ID := clientAppID(c)
+			if !isIgnored(c.Class) && !isIgnored(ID) {
+				if !isIn(alreadyAdded, ID) {
 					button := taskButton(c, instances, position)
 					mainBox.PackStart(button, false, false, 0)
-					if c.Class == activeClient.Class && !*autohide {
+					pinnedBoxes = append(pinnedBoxes, pinnedBox{button, i})
+					if isActive(ID) && !*autohide {
 						button.SetObjectProperty("name", "active")
 					} else {
 						button.SetObjectProperty("name", "")
 					}
-					alreadyAdded = append(alreadyAdded, c.Class)
-					clientMenu(c.Class, instances)
+					alreadyAdded = append(alreadyAdded, ID)
 				} else {
 					continue
 				}
@@ -206,29 +249,22 @@ func buildMainBox() {
 
 	alreadyAdded = nil
 	for _, t := range clients {
-		// For some time after killing a client, it's still being returned by 'j/clients', however w/o the Class value.
-		// Let's filter the ghosts out.
-		if !inPinned(t.Class) && t.Class != "" {
-			instances := taskInstances(t.Class)
-			if !isIn(classesToIgnore, t.Class) {
-				if len(instances) == 1 {
+		ID := clientAppID(t)
+		if !pinnedHere(ID) {
+			instances := taskInstances(ID)
+			if !isIgnored(t.Class) && !isIgnored(ID) {
+				if !isIn(alreadyAdded, ID) {
 					button := taskButton(t, instances, position)
 					mainBox.PackStart(button, false, false, 0)
-					if t.Class == activeClient.Class && !*autohide {
-						button.SetObjectProperty("name", "active")
-					} else {
-						button.SetObjectProperty("name", "")
+					if firstTaskBox == nil {
+						firstTaskBox = button
 					}
-				} else if !isIn(alreadyAdded, t.Class) {
-					button := taskButton(t, instances, position)
-					mainBox.PackStart(button, false, false, 0)
-					if t.Class == activeClient.Class && !*autohide {
+					if isActive(ID) && !*autohide {
 						button.SetObjectProperty("name", "active")
 					} else {
 						button.SetObjectProperty("name", "")
 					}
-					alreadyAdded = append(alreadyAdded, t.Class)
-					clientMenu(t.Class, instances)
+					alreadyAdded = append(alreadyAdded, ID)
 				} else {
 					continue
 				}
@@ -362,6 +398,7 @@ func main() {
 	sigToggle := sigRtmin + 1
 	sigShow := sigRtmin + 2
 	sigHide := sigRtmin + 3
+	sigPinSet := sigRtmin + 4
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", flag.CommandLine.Name())
@@ -370,6 +407,7 @@ func main() {
 		fmt.Fprintf(flag.CommandLine.Output(), " SIGRTMIN+1 (%s): toggle dock visibility (USR1 has been deprecated)\n", sigToggle)
 		fmt.Fprintf(flag.CommandLine.Output(), " SIGRTMIN+2 (%s): show the dock\n", sigShow)
 		fmt.Fprintf(flag.CommandLine.Output(), " SIGRTMIN+3 (%s): hide the dock\n", sigHide)
+		fmt.Fprintf(flag.CommandLine.Output(), " SIGRTMIN+4 (%s): reload the active pin set\n", sigPinSet)
 	}
 
 	flag.Parse()
@@ -387,6 +425,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *importPins != "" || *exportPins != "" {
+		transferPins()
+	}
+
 	his = os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
 	if his == "" {
 		log.Fatal("HYPRLAND_INSTANCE_SIGNATURE not found, terminating.")
@@ -414,7 +456,7 @@ func main() {
 
 	// Gentle SIGTERM handler thanks to reiki4040 https://gist.github.com/reiki4040/be3705f307d3cd136e85
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGUSR1, sigToggle, sigShow, sigHide)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGUSR1, sigToggle, sigShow, sigHide, sigPinSet)
 
 	go func() {
 		for {
@@ -470,12 +512,29 @@ func main() {
 				} else {
 					log.Debug("sigHide received, but I'm not resident, ignoring")
 				}
+			case sigPinSet:
+				log.Debug("sigPinSet received, reloading the active pin set")
+				glib.TimeoutAdd(0, func() bool {
+					switchPinSet(loadPinSet())
+					return false
+				})
 			default:
 				log.Warn("Unknown signal")
 			}
 		}
 	}()
 
+	createDir(stateDir())
+	migratePinFiles()
+
+	// the running instance, if any, will read the set name from the file
+	if *pinSetName != "" {
+		if !validPinSet(*pinSetName) {
+			log.Fatalf("Invalid pin set name '%s', use letters, digits, '_' and '-'", *pinSetName)
+		}
+		savePinSet(*pinSetName)
+	}
+
 	var err error
 	if !*allowMultipleInstances {
 		log.Debug("Allowing only one instance of nwg-dock-hyprland")
@@ -491,7 +550,10 @@ func main() {
 			if err == nil {
 				i, err := strconv.Atoi(pid)
 				if err == nil {
-					if *autohide || *resident {
+					if *pinSetName != "" {
+						_ = syscall.Kill(i, sigPinSet)
+						log.Infof("Sending sigPinSet to running instance and bye, bye!")
+					} else if *autohide || *resident {
 						log.Info("Running instance found, terminating...")
 					} else {
 						_ = syscall.Kill(i, sigToggle)
@@ -539,7 +601,8 @@ func main() {
 	if cacheDirectory == "" {
 		log.Panic("Couldn't determine cache directory location")
 	}
-	pinnedFile = filepath.Join(cacheDirectory, "nwg-dock-pinned")
+	pinSet = loadPinSet()
+	pinnedFile = pinnedFileFor(pinSet)
 	cssFile := filepath.Join(configDirectory, *cssFileName)
 	ignoredWorkspaces = strings.Split(*ignoreWorkspaces, ",")
 	if *ignoreWorkspaces != "" {
@@ -547,6 +610,8 @@ func main() {
 	}
 
 	appDirs = getAppDirs()
+	loadAppRules()
+	loadMenuEntries()
 
 	gtk.Init()
 
@@ -657,6 +722,10 @@ func main() {
 	win.Connect("leave-notify-event", func() {
 		if *autohide {
 			src = glib.TimeoutAdd(uint(1000), func() bool {
+				// don't hide the source of the drag, try again later
+				if draggedItem != "" {
+					return true
+				}
 				mouseInsideDock = false
 				win.Hide()
 				src = 0
@@ -670,6 +739,9 @@ func main() {
 		cancelClose()
 	})
 
+	setupDropTarget(win)
+	setupDockMenu(win)
+
 	outerBox := gtk.NewBox(outerOrientation, 0)
 	outerBox.SetObjectProperty("name", "box")
 	win.Add(outerBox)
@@ -695,7 +767,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't list clients: %s", err)
 	}
+	if ws, err := getActiveWorkspace(); err == nil {
+		activeWorkspace = *ws
+	}
 	buildMainBox()
+	watchPinFiles()
+	watchAppDirs()
 
 	win.ShowAll()
 
@@ -781,6 +858,45 @@ func main() {
 			}
 
 			s := string(buf[:n])
+			for _, line := range strings.Split(s, "\n") {
+				// closewindow>>ADDRESS
+				if strings.HasPrefix(line, "closewindow>>") {
+					address := "0x" + strings.TrimSpace(strings.TrimPrefix(line, "closewindow>>"))
+					glib.TimeoutAdd(0, func() bool {
+						closedAddresses = append(closedAddresses, address)
+						if listClients() == nil {
+							buildMainBox()
+						}
+						return false
+					})
+				}
+				// workspacev2>>ID,NAME; focusedmonv2>>MONNAME,WORKSPACEID
+				if strings.HasPrefix(line, "workspacev2>>") || strings.HasPrefix(line, "focusedmonv2>>") {
+					glib.TimeoutAdd(0, func() bool {
+						if ws, err := getActiveWorkspace(); err == nil && ws.Id != activeWorkspace.Id {
+							activeWorkspace = *ws
+							if hasWorkspacePins() {
+								buildMainBox()
+							}
+						}
+						return false
+					})
+				}
+				// openwindow>>ADDRESS,WORKSPACENAME,WINDOWCLASS,WINDOWTITLE
+				if strings.HasPrefix(line, "openwindow>>") {
+					fields := strings.SplitN(strings.TrimPrefix(line, "openwindow>>"), ",", 4)
+					if len(fields) > 2 {
+						address, class := fields[0], fields[2]
+						glib.TimeoutAdd(0, func() bool {
+							if len(launching) > 0 && listClients() == nil && windowOpened(address, class) {
+								buildMainBox()
+							}
+							return false
+						})
+					}
+				}
+			}
+
 			if strings.Contains(s, "activewindowv2") {
 				winAddr := strings.TrimSpace(strings.Split(s, "activewindowv2>>")[1])
 				if winAddr != lastWinAddr && !strings.Contains(winAddr, ">>") {
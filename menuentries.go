@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+User-defined entries of the per-window context submenu, read from the menu-entries.json file in the config directory:
+
+	[
+	  {"label": "Move to scratchpad", "dispatch": "movetoworkspacesilent special:scratchpad,address:{address}"},
+	  {"label": "Copy PID", "command": "wl-copy {pid}"},
+	  {"label": "Screenshot window", "class": "firefox", "command": "grimblast save active ~/Pictures/{class}.png"}
+	]
+
+Entries w/o window patterns (class, initial-class, title, match, as in app rules) are shown for all windows.
+The {address}, {pid}, {class}, {title} and {workspace} placeholders are replaced w/ the window's values; in shell
+commands the values are quoted already.
+*/
+type menuEntry struct {
+	windowMatch
+
+	Label    string `json:"label"`
+	Dispatch string `json:"dispatch"`
+	Command  string `json:"command"`
+}
+
+var menuEntries []*menuEntry
+
+func loadMenuEntries() {
+	path := filepath.Join(configDirectory, "menu-entries.json")
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var entries []*menuEntry
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil {
+		log.Warnf("Error parsing %s: %s", path, err)
+		return
+	}
+
+	for _, entry := range entries {
+		err = entry.validate()
+		if err != nil {
+			log.Warnf("Error in %s: %s, skipping entry", path, err)
+			continue
+		}
+		menuEntries = append(menuEntries, entry)
+	}
+	log.Infof("Loaded %v menu entries from %s", len(menuEntries), path)
+}
+
+func (entry *menuEntry) validate() error {
+	if entry.Label == "" {
+		return errors.New("no label given")
+	}
+	if (entry.Dispatch == "") == (entry.Command == "") {
+		return fmt.Errorf("'%s' needs either a dispatcher or a command", entry.Label)
+	}
+	if entry.Class == "" && entry.InitialClass == "" && entry.Title == "" {
+		return nil
+	}
+	return entry.compile()
+}
+
+func (entry *menuEntry) expand(template string, instance client, quote func(string) string) string {
+	return strings.NewReplacer(
+		"{address}", quote(instance.Address),
+		"{pid}", quote(strconv.Itoa(instance.Pid)),
+		"{class}", quote(instance.Class),
+		"{title}", quote(instance.Title),
+		"{workspace}", quote(instance.Workspace.Name),
+	).Replace(template)
+}
+
+func (entry *menuEntry) run(instance client) {
+	if entry.Dispatch != "" {
+		dispatch(entry.expand(entry.Dispatch, instance, func(s string) string { return s }))
+		return
+	}
+
+	command := entry.expand(entry.Command, instance, shellQuote)
+	cmd := exec.Command("sh", "-c", command)
+	go func() {
+		err := cmd.Run()
+		if err != nil {
+			launchFailed(command, err)
+		}
+	}()
+}
+
+// Entries matching the window, in the file order
+func menuEntryItems(instance client) []*gtk.MenuItem {
+	var items []*gtk.MenuItem
+	for _, entry := range menuEntries {
+		if !entry.matches(instance.Class, instance.InitialClass, instance.Title) {
+			continue
+		}
+		item := gtk.NewMenuItemWithLabel(entry.Label)
+		e := entry
+		item.Connect("activate", func() {
+			e.run(instance)
+		})
+		items = append(items, item)
+	}
+	return items
+}
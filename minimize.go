@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Hyprland has no minimize, so w/ the -mz argument we move windows to a special workspace instead: a click on the
+focused window's button minimizes it, a click on a minimized window's button restores it where it came from.
+*/
+
+const minimizedWorkspace = "special:minimized"
+
+var minimizedFrom = make(map[string]string) // window address -> workspace to restore it to
+
+func isMinimized(c client) bool {
+	return c.Workspace.Name == minimizedWorkspace
+}
+
+func minimizeWindow(c client) {
+	minimizedFrom[c.Address] = workspaceTarget(workspace{Id: c.Workspace.Id, Name: c.Workspace.Name})
+	log.Infof("minimize %s (%s)", c.Address, c.Class)
+	dispatch(fmt.Sprintf("movetoworkspacesilent %s,address:%s", minimizedWorkspace, c.Address))
+}
+
+// Windows minimized before the dock started, or from elsewhere, go to the active workspace
+func restoreWindow(c client) {
+	target, ok := minimizedFrom[c.Address]
+	if !ok {
+		target = strconv.Itoa(activeWorkspace.Id)
+		if ws, err := getActiveWorkspace(); err == nil {
+			target = workspaceTarget(*ws)
+		}
+	}
+	delete(minimizedFrom, c.Address)
+	log.Infof("restore %s (%s) to %s", c.Address, c.Class, target)
+	dispatch(fmt.Sprintf("movetoworkspace %s,address:%s", target, c.Address))
+	dispatch(fmt.Sprintf("focuswindow address:%s", c.Address))
+}
+
+// Handles the click on a window, if it's a minimize or restore click
+func toggleMinimized(c client) bool {
+	if !*minimize {
+		return false
+	}
+	if isMinimized(c) {
+		restoreWindow(c)
+		return true
+	}
+	if activeClient != nil && activeClient.Address == c.Address {
+		minimizeWindow(c)
+		return true
+	}
+	return false
+}
+
+// Forgets the windows closed, or moved out of the workspace w/o us
+func pruneMinimized() {
+	for address := range minimizedFrom {
+		if !slices.ContainsFunc(clients, func(c client) bool { return c.Address == address && isMinimized(c) }) {
+			delete(minimizedFrom, address)
+		}
+	}
+}
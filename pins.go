@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+The pinned file holds a JSON array of items like:
+
+	{
+	  "id": "firefox",
+	  "match": {"class": "firefox-work"},
+	  "label": "Firefox (work)",
+	  "icon": "firefox-developer-edition",
+	  "command": "firefox",
+	  "args": ["-P", "work", "--name", "firefox-work"],
+	  "env": {"MOZ_ENABLE_WAYLAND": "1"},
+	  "dir": "~/work",
+	  "rules": "workspace 2 silent;scope"
+	}
+
+Only "id" (desktop ID, or class) is mandatory. Windows matching "match" are shown as instances of the pin.
+
+Items of the "launcher" type are not apps: they just run the command (a script, a power menu), don't need .desktop
+files, and are never matched w/ windows:
+
+	{"id": "screenshot", "type": "launcher", "label": "Screenshot", "icon": "camera-photo", "command": "grim"}
+
+Items of the "group" type show their member apps (desktop IDs) in a popover:
+
+	{"id": "group-office", "type": "group", "label": "Office", "members": ["writer", "calc", "impress"]}
+*/
+type pinnedItem struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type,omitempty"` // "app" (default), "launcher" or "group"
+	Match      *windowMatch      `json:"match,omitempty"`
+	Label      string            `json:"label,omitempty"`
+	Icon       string            `json:"icon,omitempty"`
+	Command    string            `json:"command,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Dir        string            `json:"dir,omitempty"`
+	Rules      string            `json:"rules,omitempty"` // Hyprland exec rules, and the dock's "scope" / "noscope" options
+	Members    []string          `json:"members,omitempty"`
+	Workspaces []string          `json:"workspaces,omitempty"` // IDs or names; the pin is shown on all workspaces if empty
+
+	// broken items and patterns are kept, so that saving the pins doesn't lose them
+	invalid      bool // no ID, or a launcher w/o command: not shown
+	invalidMatch bool // the match patterns don't compile: not matched
+}
+
+var (
+	lastSavedPins     []byte             // the pinned file as we know it, to tell others' edits from ours
+	pinsUnparsed      bool               // the pinned file is not valid JSON, so we mustn't overwrite it
+	pinsMonitor       gio.FileMonitorrer // referenced, or it'd be garbage collected
+	pinsReload        glib.SourceHandle
+	pinsTarget        string // where the pinned file symlink points to, if outside the state directory
+	pinsTargetMonitor gio.FileMonitorrer
+)
+
+// The plain list of classes, as used up to v0.4.8
+func legacyPinnedFile() string {
+	return filepath.Join(cacheDir(), "nwg-dock-pinned")
+}
+
+// Pins used to be kept in the cache dir, and were lost w/ it: nwg-dock-pinned.json becomes pinned.json etc.
+func migratePinFiles() {
+	if cacheDir() == "" {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(cacheDir(), "nwg-dock-pinned*.json"))
+	files = append(files, filepath.Join(cacheDir(), "nwg-dock-pinset"))
+
+	for _, src := range files {
+		dst := filepath.Join(stateDir(), strings.TrimPrefix(filepath.Base(src), "nwg-dock-"))
+		if !pathExists(src) || pathExists(dst) {
+			continue
+		}
+		err := os.Rename(src, dst)
+		if err != nil {
+			// different file systems
+			err = copyFile(src, dst)
+			if err == nil {
+				err = os.Remove(src)
+			}
+		}
+		if err != nil {
+			log.Warnf("Error moving %s to %s: %s", src, dst, err)
+			continue
+		}
+		_ = os.Chmod(dst, 0644)
+		log.Infof("Moved %s to %s", src, dst)
+	}
+}
+
+func loadPinned() {
+	pinned = nil
+
+	if pinSet == "" && !pathExists(pinnedFile) && pathExists(legacyPinnedFile()) {
+		migratePinned()
+	}
+
+	pinsUnparsed = false
+	bytes, err := os.ReadFile(pinnedFile)
+	if err != nil {
+		return
+	}
+	lastSavedPins = bytes
+	err = json.Unmarshal(bytes, &pinned)
+	if err != nil {
+		log.Warnf("Error parsing %s: %s, pins won't be saved until it's fixed", pinnedFile, err)
+		pinned = nil
+		pinsUnparsed = true
+		return
+	}
+
+	for i := range pinned {
+		pin := &pinned[i]
+		if pin.ID == "" {
+			log.Warnf("Pin w/o id, skipping")
+			pin.invalid = true
+		} else if pin.isLauncher() && pin.Command == "" {
+			log.Warnf("Launcher '%s' w/o command, skipping", pin.ID)
+			pin.invalid = true
+		}
+		if pin.Match != nil {
+			err = pin.Match.compile()
+			if err != nil {
+				log.Warnf("Error in '%s' pin match: %s", pin.ID, err)
+				pin.invalidMatch = true
+			}
+		}
+	}
+}
+
+// Converts the legacy plain text file; the file itself is left in place for older dock versions
+func migratePinned() {
+	lines, err := loadTextFile(legacyPinnedFile())
+	if err != nil {
+		log.Warnf("Error reading %s: %s", legacyPinnedFile(), err)
+		return
+	}
+	for _, line := range lines {
+		ID, rules := parsePinLine(line)
+		pinned = append(pinned, pinnedItem{ID: ID, Rules: rules})
+	}
+	savePinned()
+	log.Infof("Migrated %v pin(s) from %s to %s", len(pinned), legacyPinnedFile(), pinnedFile)
+}
+
+// Lines of the legacy pinned file may carry Hyprland exec rules after the ID, e.g. "firefox [workspace 3 silent;float]"
+func parsePinLine(line string) (string, string) {
+	if strings.HasSuffix(line, "]") {
+		if idx := strings.LastIndex(line, " ["); idx != -1 {
+			return strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+2 : len(line)-1])
+		}
+	}
+	return line, ""
+}
+
+// Edits made by another instance, by hand or by a dotfile sync show up live
+func watchPinFiles() {
+	monitor, err := gio.NewFileForPath(stateDir()).MonitorDirectory(context.Background(), gio.FileMonitorWatchMoves)
+	if err != nil {
+		log.Warnf("Can't watch %s: %s", stateDir(), err)
+		return
+	}
+	pinsMonitor = monitor
+
+	gio.BaseFileMonitor(monitor).ConnectChanged(func(file, otherFile gio.Filer, eventType gio.FileMonitorEvent) {
+		if name, ok := changedFileName(file, otherFile, eventType); ok &&
+			(name == filepath.Base(pinnedFile) || name == filepath.Base(pinSetFile())) {
+			scheduleReloadPinFiles()
+		}
+	})
+	watchPinsTarget()
+}
+
+// The pinned file may be a symlink, e.g. into a dotfiles repository, and get edited where it points to
+func watchPinsTarget() {
+	target, err := filepath.EvalSymlinks(pinnedFile)
+	if err != nil || filepath.Dir(target) == filepath.Clean(stateDir()) {
+		target = ""
+	}
+	if target == pinsTarget {
+		return
+	}
+	if pinsTargetMonitor != nil {
+		gio.BaseFileMonitor(pinsTargetMonitor).Cancel()
+		pinsTargetMonitor = nil
+	}
+	pinsTarget = target
+	if target == "" {
+		return
+	}
+
+	dir := filepath.Dir(target)
+	monitor, err := gio.NewFileForPath(dir).MonitorDirectory(context.Background(), gio.FileMonitorWatchMoves)
+	if err != nil {
+		log.Warnf("Can't watch %s: %s", dir, err)
+		return
+	}
+	pinsTargetMonitor = monitor
+
+	gio.BaseFileMonitor(monitor).ConnectChanged(func(file, otherFile gio.Filer, eventType gio.FileMonitorEvent) {
+		if name, ok := changedFileName(file, otherFile, eventType); ok && name == filepath.Base(target) {
+			scheduleReloadPinFiles()
+		}
+	})
+}
+
+// The name of the file changed, if the event means new content
+func changedFileName(file, otherFile gio.Filer, eventType gio.FileMonitorEvent) (string, bool) {
+	switch eventType {
+	case gio.FileMonitorEventRenamed:
+		// atomic writes rename a temporary file
+		return otherFile.Basename(), true
+	case gio.FileMonitorEventChangesDoneHint, gio.FileMonitorEventCreated, gio.FileMonitorEventDeleted,
+		gio.FileMonitorEventMovedIn:
+		return file.Basename(), true
+	}
+	return "", false
+}
+
+// a single save may come as a series of events
+func scheduleReloadPinFiles() {
+	if pinsReload > 0 {
+		glib.SourceRemove(pinsReload)
+	}
+	pinsReload = glib.TimeoutAdd(200, func() bool {
+		pinsReload = 0
+		reloadPinFiles()
+		return false
+	})
+}
+
+func reloadPinFiles() {
+	if set := loadPinSet(); set != pinSet {
+		switchPinSet(set)
+		return
+	}
+	// the symlink may point elsewhere now
+	watchPinsTarget()
+	bytes, err := os.ReadFile(pinnedFile)
+	if err == nil && slices.Equal(bytes, lastSavedPins) {
+		return
+	}
+	log.Infof("%s changed, reloading", pinnedFile)
+	buildMainBox()
+}
+
+func savePinned() {
+	if pinsUnparsed {
+		log.Warnf("Not saving pins over %s, that couldn't be parsed", pinnedFile)
+		return
+	}
+	items := pinned
+	if items == nil {
+		items = []pinnedItem{}
+	}
+	bytes, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		log.Errorf("Error saving pinned %s", err)
+		return
+	}
+
+	bytes = append(bytes, '\n')
+	err = writeFileAtomic(pinnedFile, bytes)
+	if err != nil {
+		log.Errorf("Error saving pinned %s", err)
+		return
+	}
+	lastSavedPins = bytes
+}
+
+func (pin *pinnedItem) isApp() bool {
+	return pin.Type == "" || pin.Type == "app"
+}
+
+func (pin *pinnedItem) isLauncher() bool {
+	return pin.Type == "launcher"
+}
+
+// Launcher and group IDs are arbitrary names, so they must not be resolved
+func (pin *pinnedItem) refersTo(ID string) bool {
+	if !pin.isApp() {
+		return pin.ID == ID
+	}
+	return sameApp(pin.ID, ID)
+}
+
+func findPin(ID string) *pinnedItem {
+	// launchers and groups first, or their names might be taken for some apps' classes
+	for i := range pinned {
+		if pinned[i].ID == ID {
+			return &pinned[i]
+		}
+	}
+	for i := range pinned {
+		if pinned[i].refersTo(ID) {
+			return &pinned[i]
+		}
+	}
+	return nil
+}
+
+// Returns the pin whose match patterns the window matches, if any
+func matchPin(c client) *pinnedItem {
+	for i := range pinned {
+		if pinned[i].isApp() && pinned[i].Match != nil && !pinned[i].invalidMatch &&
+			pinned[i].Match.matches(c.Class, c.InitialClass, c.Title) {
+			return &pinned[i]
+		}
+	}
+	return nil
+}
+
+func (pin *pinnedItem) onWorkspace(ws workspace) bool {
+	return len(pin.Workspaces) == 0 || isIn(pin.Workspaces, strconv.Itoa(ws.Id)) || isIn(pin.Workspaces, ws.Name)
+}
+
+func hasWorkspacePins() bool {
+	return slices.ContainsFunc(pinned, func(pin pinnedItem) bool { return len(pin.Workspaces) > 0 })
+}
+
+// Windows of apps pinned to other workspaces only are shown as unpinned
+func pinnedHere(taskID string) bool {
+	pin := findPin(taskID)
+	return pin != nil && pin.isApp() && pin.onWorkspace(activeWorkspace)
+}
+
+// Pins the app if not yet pinned, and limits it to the workspaces; nil makes the pin global
+func setPinWorkspaces(itemID string, workspaces []string) {
+	if findPin(itemID) == nil {
+		pinTask(itemID)
+	}
+	if pin := findPin(itemID); pin != nil {
+		pin.Workspaces = workspaces
+		savePinned()
+		buildMainBox()
+	}
+}
+
+func inPinned(taskID string) bool {
+	pin := findPin(taskID)
+	return pin != nil && pin.isApp()
+}
+
+func pinTask(itemID string) {
+	pinTaskAt(itemID, len(pinned))
+}
+
+// Pins the item before the one at index
+func pinTaskAt(itemID string, index int) {
+	if pin := findPin(itemID); pin != nil {
+		println(pin.ID, "already pinned")
+		return
+	}
+	pinned = slices.Insert(pinned, min(index, len(pinned)), pinnedItem{ID: resolveAppID(itemID)})
+	savePinned()
+}
+
+func unpinTask(itemID string) {
+	pinned = slices.DeleteFunc(pinned, func(pin pinnedItem) bool {
+		return pin.refersTo(itemID)
+	})
+	savePinned()
+	buildMainBox()
+}
+
+func getPinRules(ID string) string {
+	if pin := findPin(ID); pin != nil {
+		return pin.Rules
+	}
+	return ""
+}
+
+// "scope" and "noscope" are the dock's own options, the rest of the pin's rules is for Hyprland
+func hyprRules(ID string) string {
+	var rules []string
+	for _, rule := range strings.Split(getPinRules(ID), ";") {
+		rule = strings.TrimSpace(rule)
+		if rule != "" && rule != "scope" && rule != "noscope" {
+			rules = append(rules, rule)
+		}
+	}
+	return strings.Join(rules, ";")
+}
+
+func hasPinOption(ID, option string) bool {
+	for _, rule := range strings.Split(getPinRules(ID), ";") {
+		if strings.TrimSpace(rule) == option {
+			return true
+		}
+	}
+	return false
+}
+
+func useScope(ID string) bool {
+	if hasPinOption(ID, "noscope") {
+		return false
+	}
+	return *scope || hasPinOption(ID, "scope")
+}
+
+// Returns the pin's env variables as KEY=value, sorted, so that the log is readable
+func (pin *pinnedItem) envVars() []string {
+	var vars []string
+	for k, v := range pin.Env {
+		vars = append(vars, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(vars)
+	return vars
+}
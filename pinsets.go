@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gdk/v3"
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Named pin sets, e.g. "work" or "gaming", each in its own pinned-<name>.json file. The unnamed default set is
+the pinned.json file. The name of the active set is persisted in the pinset file.
+*/
+
+const defaultPinSet = "default"
+
+var (
+	pinSet        string // the active set, "" for the default one
+	pinSetPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+func pinSetFile() string {
+	return filepath.Join(stateDir(), "pinset")
+}
+
+func pinnedFileFor(set string) string {
+	if set == "" {
+		return filepath.Join(stateDir(), "pinned.json")
+	}
+	return filepath.Join(stateDir(), fmt.Sprintf("pinned-%s.json", set))
+}
+
+// Set names end up in file names
+func validPinSet(set string) bool {
+	return set == defaultPinSet || pinSetPattern.MatchString(set)
+}
+
+func loadPinSet() string {
+	set, err := readTextFile(pinSetFile())
+	set = strings.TrimSpace(set)
+	if err != nil || set == defaultPinSet || !validPinSet(set) {
+		return ""
+	}
+	return set
+}
+
+func savePinSet(set string) {
+	if set == defaultPinSet {
+		set = ""
+	}
+	err := writeFileAtomic(pinSetFile(), []byte(set))
+	if err != nil {
+		log.Warnf("Error saving the active pin set: %s", err)
+	}
+}
+
+// Names of the existing sets, the default one first
+func listPinSets() []string {
+	sets := []string{""}
+	files, _ := filepath.Glob(filepath.Join(stateDir(), "pinned-*.json"))
+	for _, f := range files {
+		set := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "pinned-"), ".json")
+		if validPinSet(set) {
+			sets = append(sets, set)
+		}
+	}
+	if !slices.Contains(sets, pinSet) {
+		sets = append(sets, pinSet)
+	}
+	return sets
+}
+
+func switchPinSet(set string) {
+	if set == defaultPinSet {
+		set = ""
+	}
+	log.Infof("Switching to the '%s' pin set", pinSetLabel(set))
+	pinSet = set
+	pinnedFile = pinnedFileFor(set)
+	savePinSet(set)
+	watchPinsTarget()
+	buildMainBox()
+}
+
+func pinSetLabel(set string) string {
+	if set == "" {
+		return defaultPinSet
+	}
+	return set
+}
+
+// "Pin set" submenu, w/ the existing sets, and an item to create a new one from the current pins
+func pinSetMenuItem() *gtk.MenuItem {
+	menuItem := gtk.NewMenuItemWithLabel("Pin set")
+	submenu := gtk.NewMenu()
+	for _, set := range listPinSets() {
+		setItem := gtk.NewCheckMenuItemWithLabel(pinSetLabel(set))
+		setItem.SetDrawAsRadio(true)
+		setItem.SetActive(set == pinSet)
+		name := set
+		setItem.Connect("activate", func() {
+			if name != pinSet {
+				switchPinSet(name)
+			}
+		})
+		submenu.Append(&setItem.MenuItem)
+	}
+
+	newItem := gtk.NewMenuItemWithLabel("New set…")
+	newItem.Connect("activate", func() {
+		askText("New pin set", "", func(name string) {
+			if !validPinSet(name) || name == defaultPinSet {
+				log.Warnf("Invalid pin set name '%s', use letters, digits, '_' and '-'", name)
+				return
+			}
+			if !pathExists(pinnedFileFor(name)) {
+				pinnedFile = pinnedFileFor(name)
+				savePinned()
+			}
+			switchPinSet(name)
+		})
+	})
+	submenu.Append(newItem)
+	menuItem.SetSubmenu(submenu)
+
+	return menuItem
+}
+
+// Context menu of the dock itself, opened on the background
+func dockMenuContext() gtk.Menu {
+	menu := gtk.NewMenu()
+	menu.Append(pinSetMenuItem())
+	menu.ShowAll()
+	return *menu
+}
+
+// Buttons don't claim right presses, so they bubble up here; only the ones received by the window itself are ours
+func setupDockMenu(win *gtk.Window) {
+	win.Connect("button-press-event", func(w *gtk.Window, e *gdk.Event) bool {
+		target := gtk.GetEventWidget(e)
+		if e.AsButton().Button() == 3 && target != nil && gtk.BaseWidget(target).Native() == w.Native() {
+			menu := dockMenuContext()
+			menu.PopupAtPointer(nil)
+			return true
+		}
+		return false
+	})
+}
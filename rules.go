@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+User-defined class -> app mapping rules, for stubborn apps the automatic resolution can't handle. Read from the
+app-rules.json file in the config directory, e.g.:
+
+	[
+	  {"class": "jetbrains-idea", "desktop-id": "idea"},
+	  {"title": "^Spotify", "match": "regex", "desktop-id": "spotify"},
+	  {"class": "*.exe", "match": "glob", "icon": "wine", "name": "Wine app"}
+	]
+
+All the non-empty class, initial-class and title patterns must match. The first matching rule wins.
+*/
+type appRule struct {
+	windowMatch
+
+	DesktopID string `json:"desktop-id"`
+	Icon      string `json:"icon"`
+	Name      string `json:"name"`
+	Exec      string `json:"exec"`
+}
+
+// Window patterns, shared w/ pins
+type windowMatch struct {
+	Class        string `json:"class,omitempty"`
+	InitialClass string `json:"initial-class,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Match        string `json:"match,omitempty"` // "exact" (default), "glob" or "regex"
+
+	classRe, initialClassRe, titleRe *regexp.Regexp
+}
+
+var (
+	appRules     []*appRule
+	appOverrides = make(map[string]*appRule) // app ID -> rule overriding its icon, name or command
+)
+
+func loadAppRules() {
+	path := filepath.Join(configDirectory, "app-rules.json")
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var rules []*appRule
+	err = json.Unmarshal(bytes, &rules)
+	if err != nil {
+		log.Warnf("Error parsing %s: %s", path, err)
+		return
+	}
+
+	for _, rule := range rules {
+		err = rule.compile()
+		if err != nil {
+			log.Warnf("Error in %s: %s, skipping rule", path, err)
+			continue
+		}
+		appRules = append(appRules, rule)
+
+		// pins are stored by desktop ID, let them get the overrides before any window matched
+		if rule.DesktopID != "" && (rule.Icon != "" || rule.Name != "" || rule.Exec != "") {
+			appOverrides[strings.TrimSuffix(rule.DesktopID, ".desktop")] = rule
+		}
+	}
+	log.Infof("Loaded %v app rule(s) from %s", len(appRules), path)
+}
+
+func (wm *windowMatch) compile() error {
+	if wm.Class == "" && wm.InitialClass == "" && wm.Title == "" {
+		return errors.New("no pattern given")
+	}
+	if wm.Match != "regex" {
+		return nil
+	}
+
+	var err error
+	wm.classRe, err = compilePattern(wm.Class)
+	if err == nil {
+		wm.initialClassRe, err = compilePattern(wm.InitialClass)
+	}
+	if err == nil {
+		wm.titleRe, err = compilePattern(wm.Title)
+	}
+	return err
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// Pins are matched by class only, so title rules need the title
+func matchAppRule(class, initialClass, title string) *appRule {
+	for _, rule := range appRules {
+		if rule.matches(class, initialClass, title) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (wm *windowMatch) matches(class, initialClass, title string) bool {
+	return wm.matchesPattern(wm.Class, wm.classRe, class) &&
+		wm.matchesPattern(wm.InitialClass, wm.initialClassRe, initialClass) &&
+		wm.matchesPattern(wm.Title, wm.titleRe, title)
+}
+
+func (wm *windowMatch) matchesPattern(pattern string, re *regexp.Regexp, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	switch wm.Match {
+	case "glob":
+		matched, _ := filepath.Match(pattern, value)
+		return matched
+	case "regex":
+		return re.MatchString(value)
+	default:
+		return pattern == value
+	}
+}
+
+func appOverride(appName string) *appRule {
+	return appOverrides[resolveAppID(appName)]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	steamGameClass = regexp.MustCompile(`^steam_app_(\d+)$`)
+	vdfPath        = regexp.MustCompile(`"path"\s+"([^"]+)"`)
+	vdfName        = regexp.MustCompile(`"name"\s+"([^"]+)"`)
+	noIconTheme    bool // w/o GTK initialized, as when transferring pins
+)
+
+/*
+Steam games show up as steam_app_<id>. Steam creates .desktop files w/ the steam://rungameid/<id> command only for
+games w/ a desktop shortcut. For the rest, let's build the identity from the hicolor steam_icon_<id> icon,
+and the game name from the library's appmanifest_<id>.acf file.
+*/
+func steamGameIdentity(class string) (appIdentity, bool) {
+	m := steamGameClass.FindStringSubmatch(class)
+	if m == nil {
+		return appIdentity{}, false
+	}
+	gameID := m[1]
+
+	url := fmt.Sprintf("steam://rungameid/%s", gameID)
+	for _, p := range getDesktopFiles() {
+		if strings.HasSuffix(getDesktopEntryValue(p, "Exec"), url) {
+			return identityFromPath(p), true
+		}
+	}
+
+	rule := &appRule{Icon: "steam", Name: steamGameName(gameID), Exec: fmt.Sprintf("xdg-open %s", url)}
+	if icon := fmt.Sprintf("steam_icon_%s", gameID); !noIconTheme && gtk.IconThemeGetDefault().HasIcon(icon) {
+		rule.Icon = icon
+	}
+	if rule.Name == "" {
+		rule.Name = class
+	}
+	appOverrides[class] = rule
+	log.Debugf("Steam game %s: '%s'", gameID, rule.Name)
+
+	return appIdentity{ID: class}, true
+}
+
+// Steam may be installed natively, as Flatpak or as Snap
+func steamRoots() []string {
+	home := os.Getenv("HOME")
+	return []string{
+		filepath.Join(home, ".local/share/Steam"),
+		filepath.Join(home, ".steam/steam"),
+		filepath.Join(home, ".var/app/com.valvesoftware.Steam/.local/share/Steam"),
+		filepath.Join(home, "snap/steam/common/.local/share/Steam"),
+	}
+}
+
+// Returns the game name from appmanifest_<id>.acf in any of the Steam libraries
+func steamGameName(gameID string) string {
+	var libraries []string
+	for _, root := range steamRoots() {
+		libraries = append(libraries, root)
+		vdf, err := readTextFile(filepath.Join(root, "steamapps/libraryfolders.vdf"))
+		if err != nil {
+			continue
+		}
+		for _, m := range vdfPath.FindAllStringSubmatch(vdf, -1) {
+			if !isIn(libraries, m[1]) {
+				libraries = append(libraries, m[1])
+			}
+		}
+	}
+
+	for _, library := range libraries {
+		acf, err := readTextFile(filepath.Join(library, "steamapps", fmt.Sprintf("appmanifest_%s.acf", gameID)))
+		if err != nil {
+			continue
+		}
+		if m := vdfName.FindStringSubmatch(acf); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -14,13 +15,21 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
+type pendingLaunch struct {
+	pid     int
+	timeout glib.SourceHandle
+}
+
 func taskInstances(ID string) []client {
 	var found []client
 	for _, c := range clients {
-		if strings.ToUpper(c.Class) == strings.ToUpper(ID) {
+		if sameApp(clientAppID(c), ID) {
 			found = append(found, c)
 		}
 	}
@@ -37,7 +46,14 @@ func pinnedButton(ID string, position *string) *gtk.Box {
 
 	button := gtk.NewButton()
 
-	image, err := createImage(ID, imgSizeScaled)
+	pin := findPin(ID)
+	var image *gtk.Image
+	var err error
+	if pin != nil && pin.isGroup() {
+		image = groupImage(pin, imgSizeScaled)
+	} else {
+		image, err = createImage(ID, imgSizeScaled)
+	}
 	if err != nil || image == nil {
 		pixbuf, err := gdkpixbuf.NewPixbufFromFileAtSize(filepath.Join(dataHome, "nwg-dock-hyprland/images/icon-missing.svg"),
 			imgSizeScaled, imgSizeScaled)
@@ -53,17 +69,40 @@ func pinnedButton(ID string, position *string) *gtk.Box {
 	button.SetAlwaysShowImage(true)
 	button.SetTooltipText(getName(ID))
 
-	button.Connect("clicked", func() {
-		launch(ID)
-	})
+	// launchers run scripts rather than apps, there's no window to wait for
+	isApp := pin == nil || pin.isApp()
+	isGroup := pin != nil && pin.isGroup()
+	if isApp {
+		markLaunching(ID, button)
+	}
+	clicked := func() {
+		if isGroup {
+			showGroup(ID, button)
+		} else if !isApp {
+			launch(ID)
+		} else {
+			launchWithFeedback(ID, button)
+		}
+	}
+
+	button.Connect("clicked", clicked)
+	setupDragSource(button, ID)
+	if isGroup {
+		setupGroupDrop(button, ID)
+	} else {
+		setupButtonDrop(button, ID)
+	}
 
 	button.Connect("button-release-event", func(btn *gtk.Button, e *gdk.Event) bool {
 		btnEvent := e.AsButton()
 		if btnEvent.Button() == 1 || btnEvent.Button() == 2 {
-			launch(ID)
+			clicked()
 			return true
 		} else if btnEvent.Button() == 3 {
 			contextMenu := pinnedMenuContext(ID)
+			if isGroup {
+				contextMenu = groupMenuContext(ID)
+			}
 			contextMenu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 			return true
 		}
@@ -102,6 +141,10 @@ func pinnedMenuContext(taskID string) gtk.Menu {
 		unpinTask(taskID)
 	})
 	menu.Append(menuItem)
+	if pin := findPin(taskID); pin == nil || pin.isApp() {
+		menu.Append(workspaceMenuItem(taskID))
+		menu.Append(addToGroupMenuItem(taskID))
+	}
 
 	menu.ShowAll()
 	return *menu
@@ -136,7 +179,7 @@ func launcherButton(position *string) *gtk.Box {
 				go func() {
 					err := cmd.Run()
 					if err != nil {
-						log.Warnf("Unable to start program: %s", err.Error())
+						launchFailed(*launcherCmd, err)
 					}
 				}()
 
@@ -193,7 +236,9 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 
 	button := gtk.NewButton()
 
-	image, _ := createImage(t.Class, imgSizeScaled)
+	ID := clientAppID(t)
+
+	image, _ := createImage(ID, imgSizeScaled)
 	if image == nil {
 		//var pixbuf *gdk.Pixbuf
 		//var err error
@@ -210,7 +255,13 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 		button.SetImagePosition(gtk.PosTop)
 		button.SetAlwaysShowImage(true)
 	}
-	button.SetTooltipText(getName(t.Class))
+	button.SetTooltipText(getName(ID))
+	if !slices.ContainsFunc(instances, func(c client) bool { return !isMinimized(c) }) {
+		button.StyleContext().AddClass("minimized")
+	}
+	markLaunching(ID, button)
+	setupDragSource(button, ID)
+	setupButtonDrop(button, ID)
 
 	var img *gtk.Image
 	var pixbuf *gdkpixbuf.Pixbuf
@@ -260,6 +311,9 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 			btnEvent := e.AsButton()
 			if btnEvent.Type() == gdk.ButtonReleaseType || btnEvent.Type() == gdk.TouchEndType {
 				if btnEvent.Button() == 1 || btnEvent.Type() == gdk.TouchEndType {
+					if toggleMinimized(t) {
+						return true
+					}
 					cmd := fmt.Sprintf("dispatch focuswindow address:%s", t.Address)
 					if strings.HasPrefix(t.Workspace.Name, "special") {
 						_, specialName, _ := strings.Cut(t.Workspace.Name, "special:")
@@ -275,10 +329,10 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 
 					return true
 				} else if btnEvent.Button() == 2 {
-					launch(t.Class)
+					launchWithFeedback(ID, button)
 					return true
 				} else if btnEvent.Button() == 3 {
-					contextMenu := clientMenuContext(t.Class, instances)
+					contextMenu := clientMenuContext(ID, instances)
 					contextMenu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 					return true
 				}
@@ -289,14 +343,14 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 		button.Connect("button-release-event", func(btn *gtk.Button, e *gdk.Event) bool {
 			btnEvent := e.AsButton()
 			if btnEvent.Button() == 1 {
-				menu := clientMenu(t.Class, instances)
+				menu := clientMenu(ID, instances)
 				menu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 				return true
 			} else if btnEvent.Button() == 2 {
-				launch(t.Class)
+				launchWithFeedback(ID, button)
 				return true
 			} else if btnEvent.Button() == 3 {
-				contextMenu := clientMenuContext(t.Class, instances)
+				contextMenu := clientMenuContext(ID, instances)
 				contextMenu.PopupAtWidget(button, widgetAnchor, menuAnchor, nil)
 				return true
 			}
@@ -307,10 +361,10 @@ func taskButton(t client, instances []client, position *string) *gtk.Box {
 	return box
 }
 
-func clientMenu(class string, instances []client) gtk.Menu {
+func clientMenu(ID string, instances []client) gtk.Menu {
 	menu := gtk.NewMenu()
 
-	iconName, err := getIcon(class)
+	iconName, err := getIcon(ID)
 	if err != nil {
 		log.Warn(err)
 	}
@@ -330,7 +384,12 @@ func clientMenu(class string, instances []client) gtk.Menu {
 		menuItem.Add(hbox)
 		menu.Append(menuItem)
 		a := instance.Address
+		c := instance
 		menuItem.Connect("activate", func() {
+			if *minimize && isMinimized(c) {
+				restoreWindow(c)
+				return
+			}
 			cmd := fmt.Sprintf("dispatch focuswindow address:%s", a)
 			if strings.HasPrefix(wsName, "special") {
 				_, specialName, _ := strings.Cut(wsName, "special:")
@@ -349,12 +408,12 @@ func clientMenu(class string, instances []client) gtk.Menu {
 	return *menu
 }
 
-func clientMenuContext(class string, instances []client) gtk.Menu {
+func clientMenuContext(ID string, instances []client) gtk.Menu {
 	menu := gtk.NewMenu()
 
-	iconName, err := getIcon(class)
+	iconName, err := getIcon(ID)
 	if err != nil {
-		log.Warnf("%s %s", err, class)
+		log.Warnf("%s %s", err, ID)
 	}
 	for _, instance := range instances {
 		menuItem := gtk.NewMenuItem()
@@ -376,56 +435,14 @@ func clientMenuContext(class string, instances []client) gtk.Menu {
 		hbox.PackStart(label, false, false, 0)
 		menuItem.Add(hbox)
 		menu.Append(menuItem)
-		submenu := gtk.NewMenu()
-
-		a := instance.Address
-
-		subitem := gtk.NewMenuItemWithLabel("closewindow")
-		submenu.Append(subitem)
-		subitem.Connect("activate", func() {
-			cmd := fmt.Sprintf("dispatch closewindow address:%s", a)
-			reply, _ := hyprctl(cmd)
-			log.Debugf("%s -> %s", cmd, reply)
-		})
-
-		subitem = gtk.NewMenuItemWithLabel("togglefloating")
-		submenu.Append(subitem)
-		subitem.Connect("activate", func() {
-			cmd := fmt.Sprintf("dispatch togglefloating address:%s", a)
-			reply, _ := hyprctl(cmd)
-			log.Debugf("%s -> %s", cmd, reply)
-		})
-
-		subitem = gtk.NewMenuItemWithLabel("fullscreen")
-		submenu.Append(subitem)
-		subitem.Connect("activate", func() {
-			cmd := fmt.Sprintf("dispatch fullscreen address:%s", a)
-			reply, _ := hyprctl(cmd)
-			log.Debugf("%s -> %s", cmd, reply)
-		})
-
-		s := gtk.NewSeparatorMenuItem()
-		submenu.Append(&s.MenuItem)
-
-		for i := 1; i < int(*numWS)+1; i++ {
-			subItem := gtk.NewMenuItemWithLabel(fmt.Sprintf("-> WS %v", i))
-			target := i
-			subItem.Connect("activate", func() {
-				cmd := fmt.Sprintf("dispatch movetoworkspace %v,address:%v", target, a)
-				reply, _ := hyprctl(cmd)
-				log.Debugf("%s -> %s", cmd, reply)
-			})
-			submenu.Append(subItem)
-		}
-
-		menuItem.SetSubmenu(submenu)
+		menuItem.SetSubmenu(windowMenu(instance))
 	}
 	separator := gtk.NewSeparatorMenuItem()
 	menu.Append(&separator.MenuItem)
 
 	item := gtk.NewMenuItemWithLabel("New window")
 	item.Connect("activate", func() {
-		launch(class)
+		launchWithFeedback(ID, nil)
 	})
 	menu.Append(item)
 
@@ -442,37 +459,71 @@ func clientMenuContext(class string, instances []client) gtk.Menu {
 	menu.Append(closeAllWindows)
 
 	pinItem := gtk.NewMenuItem()
-	if !inPinned(class) {
+	if !inPinned(ID) {
 		pinItem.SetLabel("Pin")
 		pinItem.Connect("activate", func() {
-			log.Infof("pin %s", class)
-			pinTask(class)
+			log.Infof("pin %s", ID)
+			pinTask(ID)
 		})
 	} else {
 		pinItem.SetLabel("Unpin")
 		pinItem.Connect("activate", func() {
-			log.Infof("unpin %s", class)
-			unpinTask(class)
+			log.Infof("unpin %s", ID)
+			unpinTask(ID)
 		})
 	}
 	menu.Append(pinItem)
+	menu.Append(workspaceMenuItem(ID))
+	menu.Append(addToGroupMenuItem(ID))
 
 	menu.ShowAll()
 	return *menu
 }
 
-func inPinned(taskID string) bool {
-	for _, id := range pinned {
-		if strings.TrimSpace(taskID) == strings.TrimSpace(id) {
+// Limits the pin to the active workspace, or makes a limited pin global again
+func workspaceMenuItem(ID string) *gtk.MenuItem {
+	if pin := findPin(ID); pin != nil && len(pin.Workspaces) > 0 {
+		menuItem := gtk.NewMenuItemWithLabel("Pin on all workspaces")
+		menuItem.Connect("activate", func() {
+			setPinWorkspaces(ID, nil)
+		})
+		return menuItem
+	}
+
+	ws := activeWorkspace.Name
+	if ws == "" {
+		ws = strconv.Itoa(activeWorkspace.Id)
+	}
+	menuItem := gtk.NewMenuItemWithLabel("Pin to this workspace only")
+	menuItem.Connect("activate", func() {
+		log.Infof("pin %s to workspace %s", ID, ws)
+		setPinWorkspaces(ID, []string{ws})
+	})
+	return menuItem
+}
+
+// Windows of killed clients may still be listed for a while; real windows may lack the class (some Xwayland, Java)
+func isGhost(c client) bool {
+	return c.Address == "" || !c.Mapped || isIn(closedAddresses, c.Address)
+}
+
+// The -g argument may list either classes or app IDs
+func isIgnored(ID string) bool {
+	for _, item := range classesToIgnore {
+		if item != "" && (strings.EqualFold(item, ID) || sameApp(item, ID)) {
 			return true
 		}
 	}
 	return false
 }
 
+func isActive(ID string) bool {
+	return activeClient != nil && activeClient.Address != "" && sameApp(clientAppID(*activeClient), ID)
+}
+
 func inTasks(pinID string) bool {
 	for _, task := range clients {
-		if strings.TrimSpace(task.Class) == strings.TrimSpace(pinID) {
+		if sameApp(clientAppID(task), pinID) {
 			return true
 		}
 	}
@@ -539,6 +590,42 @@ func cacheDir() string {
 	return ""
 }
 
+// Pins are kept here, as they're neither config, nor cache, that may be wiped any time
+func stateDir() string {
+	if os.Getenv("XDG_STATE_HOME") != "" {
+		return filepath.Join(os.Getenv("XDG_STATE_HOME"), "nwg-dock-hyprland")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local/state/nwg-dock-hyprland")
+}
+
+// Readers (e.g. other dock instances) never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	// replacing a symlink would cut the file off from where it points, e.g. a stow-managed dotfile repo
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
 func tempDir() string {
 	if os.Getenv("TMPDIR") != "" {
 		return os.Getenv("TMPDIR")
@@ -647,10 +734,10 @@ func getAppDirs() []string {
 	for _, d := range strings.Split(xdgDataDirs, ":") {
 		dirs = append(dirs, filepath.Join(d, "applications"))
 	}
-	flatpakDirs := []string{filepath.Join(home, ".local/share/flatpak/exports/share/applications"),
-		"/var/lib/flatpak/exports/share/applications"}
+	sandboxDirs := []string{filepath.Join(home, ".local/share/flatpak/exports/share/applications"),
+		"/var/lib/flatpak/exports/share/applications", "/var/lib/snapd/desktop/applications"}
 
-	for _, d := range flatpakDirs {
+	for _, d := range sandboxDirs {
 		if !isIn(dirs, d) {
 			dirs = append(dirs, d)
 		}
@@ -668,36 +755,22 @@ func isIn(slice []string, val string) bool {
 }
 
 func getIcon(appName string) (string, error) {
-	appName = strings.Split(appName, " ")[0]
-	if strings.HasPrefix(strings.ToUpper(appName), "GIMP") {
-		return "gimp", nil
-	}
-	p := ""
-	for _, d := range appDirs {
-		path := filepath.Join(d, fmt.Sprintf("%s.desktop", appName))
-		if pathExists(path) {
-			p = path
-			break
-		} else if pathExists(strings.ToLower(path)) {
-			p = strings.ToLower(path)
-			break
+	if pin := findPin(appName); pin != nil && (pin.Icon != "" || !pin.isApp()) {
+		if pin.Icon != "" {
+			return pin.Icon, nil
+		} else if pin.isGroup() {
+			return "folder", nil
 		}
+		return "application-x-executable", nil
 	}
-	/* Some apps' class varies from their .desktop file name, e.g. 'gimp-2.9.9' or 'pamac-manager'.
-	   Let's try to find a matching .desktop file name */
-	if !strings.HasPrefix(appName, "/") && p == "" { // skip icon paths given instead of names
-		p = searchDesktopDirs(appName)
+	if rule := appOverride(appName); rule != nil && rule.Icon != "" {
+		return rule.Icon, nil
 	}
-
+	p := resolveApp(appName).Path
 	if p != "" {
-		lines, err := loadTextFile(p)
-		if err != nil {
-			return "", err
-		}
-		for _, line := range lines {
-			if strings.HasPrefix(strings.ToUpper(line), "ICON") {
-				return strings.Split(line, "=")[1], nil
-			}
+		icon := getDesktopEntryValue(p, "Icon")
+		if icon != "" {
+			return icon, nil
 		}
 	}
 	return "", errors.New("couldn't find the icon")
@@ -755,88 +828,96 @@ func searchDesktopDirs(badAppID string) string {
 	return ""
 }
 
-func getExec(appName string) (string, error) {
-	cmd := appName
-	if strings.HasPrefix(strings.ToUpper(appName), "GIMP") {
-		cmd = "gimp"
-	}
-	path := ""
+// Looks for the <appName>.desktop or <appname>.desktop file only
+func exactDesktopFile(appName string) string {
 	for _, d := range appDirs {
 		files, _ := os.ReadDir(d)
 		for _, f := range files {
 			if strings.HasSuffix(f.Name(), ".desktop") {
 				if f.Name() == fmt.Sprintf("%s.desktop", appName) ||
 					f.Name() == fmt.Sprintf("%s.desktop", strings.ToLower(appName)) {
-					path = filepath.Join(d, f.Name())
-					break
+					return filepath.Join(d, f.Name())
 				}
 			}
 		}
 	}
+	return ""
+}
 
-	// as above in getIcon - for tasks w/ improper app_id
-	if path == "" {
-		path = searchDesktopDirs(appName)
+func getExec(appName string) (string, error) {
+	cmd := rawExec(appName)
+	if cmd == "" {
+		return appName, nil
 	}
-
-	if path != "" {
-		lines, err := loadTextFile(path)
-		if err != nil {
-			return "", err
-		}
-		for _, line := range lines {
-			if strings.HasPrefix(strings.ToUpper(line), "EXEC") {
-				l := line[5:]
-				cutAt := strings.Index(l, "%")
-				if cutAt != -1 {
-					l = l[:cutAt-1]
-				}
-				cmd = l
-				break
-			}
-		}
-		return cmd, nil
+	cutAt := strings.Index(cmd, "%")
+	if cutAt != -1 {
+		cmd = strings.TrimSpace(cmd[:cutAt])
 	}
 
 	return cmd, nil
 }
 
-func getName(appName string) string {
-	name := appName
-	path := ""
+// Returns the Exec line w/ field codes, from the user's rule or the .desktop file
+func rawExec(appName string) string {
+	if rule := appOverride(appName); rule != nil && rule.Exec != "" {
+		return rule.Exec
+	}
+	if path := resolveApp(appName).Path; path != "" {
+		return getDesktopEntryValue(path, "Exec")
+	}
+	return ""
+}
 
-	for _, d := range appDirs {
-		files, _ := os.ReadDir(d)
-		for _, f := range files {
-			if strings.HasSuffix(f.Name(), ".desktop") {
-				if f.Name() == fmt.Sprintf("%s.desktop", appName) ||
-					f.Name() == fmt.Sprintf("%s.desktop", strings.ToLower(appName)) {
-					path = filepath.Join(d, f.Name())
-					break
-				}
+// Returns the value of the key from the [Desktop Entry] group of the .desktop file
+func getDesktopEntryValue(path, key string) string {
+	return getDesktopEntryKey(path, "Desktop Entry", key)
+}
+
+// Reads the key of the group from a key file: .desktop, Plank's .dockitem etc.
+func getDesktopEntryKey(path, groupName, key string) string {
+	lines, err := loadTextFile(path)
+	if err != nil {
+		return ""
+	}
+	group := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "[") {
+			group = line
+			continue
+		}
+		if group == "["+groupName+"]" {
+			k, v, found := strings.Cut(line, "=")
+			if found && strings.TrimSpace(k) == key {
+				return strings.TrimSpace(v)
 			}
 		}
 	}
+	return ""
+}
 
-	// as above in getIcon - for tasks w/ improper app_id
-	if path == "" {
-		path = searchDesktopDirs(appName)
+func isDBusActivatable(appName string) bool {
+	if rule := appOverride(appName); rule != nil && rule.Exec != "" {
+		return false
 	}
+	path := resolveApp(appName).Path
+	return path != "" && getDesktopEntryValue(path, "DBusActivatable") == "true"
+}
 
+func getName(appName string) string {
+	if pin := findPin(appName); pin != nil && pin.Label != "" {
+		return pin.Label
+	}
+	if rule := appOverride(appName); rule != nil && rule.Name != "" {
+		return rule.Name
+	}
+	path := resolveApp(appName).Path
 	if path != "" {
-		lines, err := loadTextFile(path)
-		if err != nil {
+		name := getDesktopEntryValue(path, "Name")
+		if name != "" {
 			return name
 		}
-		for _, line := range lines {
-			if strings.HasPrefix(strings.ToUpper(line), "NAME=") {
-				name = line[5:]
-				break
-			}
-		}
 	}
-
-	return name
+	return appName
 }
 
 func pathExists(name string) bool {
@@ -865,106 +946,412 @@ func loadTextFile(path string) ([]string, error) {
 	return output, nil
 }
 
-func pinTask(itemID string) {
-	for _, item := range pinned {
-		if item == itemID {
-			println(item, "already pinned")
-			return
+// Returns the PID of the started process, or 0 if unknown, and the error if the command couldn't be started
+func launch(ID string) (int, error) {
+	pin := findPin(ID)
+	custom := pin != nil && pin.Command != ""
+
+	// Hyprland exec rules may only apply to processes started w/ the dispatcher, so such pins skip D-Bus activation
+	if !custom && hyprRules(ID) == "" && isDBusActivatable(ID) {
+		activateApp(resolveAppID(ID), func(err error) {
+			if err == nil {
+				log.Infof("Activated '%s' over D-Bus", ID)
+				return
+			}
+			log.Warnf("D-Bus activation of '%s' failed, falling back to Exec: %s", ID, err)
+			pid, err := launchExec(ID)
+			if p, ok := launching[resolveAppID(ID)]; ok {
+				if err != nil {
+					finishLaunch(resolveAppID(ID))
+					buildMainBox()
+				} else {
+					p.pid = pid
+				}
+			}
+		})
+		if *autohide {
+			win.Hide()
 		}
+		return 0, nil
 	}
-	pinned = append(pinned, itemID)
-	savePinned()
-}
 
-func unpinTask(itemID string) {
-	pinned = remove(pinned, itemID)
-	savePinned()
-	buildMainBox()
+	return launchExec(ID)
 }
 
-func remove(s []string, r string) []string {
-	for i, v := range s {
-		if v == r {
-			return append(s[:i], s[i+1:]...)
+// Starts the pin's custom command, or the Exec command
+func launchExec(ID string) (int, error) {
+	pin := findPin(ID)
+
+	var command string
+	if pin != nil && pin.Command != "" {
+		var fields []string
+		for _, field := range append([]string{pin.Command}, pin.Args...) {
+			fields = append(fields, shellQuote(field))
+		}
+		command = strings.Join(fields, " ")
+	} else {
+		var err error
+		command, err = getExec(ID)
+		if err != nil {
+			log.Errorf("%s", err)
 		}
 	}
-	return s
+
+	return launchCommand(ID, command, nil)
 }
 
-func savePinned() {
-	f, err := os.OpenFile(pinnedFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-	if err != nil {
-		log.Fatal(err)
+// Starts the app's command, w/ extra arguments (e.g. files to open) appended
+func launchCommand(ID, command string, extraArgs []string) (int, error) {
+	pin := findPin(ID)
+	custom := pin != nil && pin.Command != ""
+
+	// pins w/ exec rules need the dispatcher, as only Hyprland knows how to apply them
+	if *hyprExec || hyprRules(ID) != "" {
+		for _, arg := range extraArgs {
+			command += " " + shellQuote(arg)
+		}
+		return 0, launchWithHyprland(ID, command, hyprRules(ID))
 	}
 
-	defer f.Close()
+	var name string
+	var cmdArgs, envVars []string
+	if custom {
+		name, cmdArgs = pin.Command, pin.Args
+		log.Infof("custom command: '%s'; args: %s", name, cmdArgs)
+	} else {
+		// remove quotation marks if any
+		if strings.Contains(command, "\"") {
+			command = strings.ReplaceAll(command, "\"", "")
+		}
+
+		elements := strings.Split(command, " ")
 
-	for _, line := range pinned {
-		if line != "" {
-			_, err := f.WriteString(line + "\n")
+		// find prepended env variables, if any
+		envVarsNum := strings.Count(command, "=")
 
-			if err != nil {
-				log.Errorf("Error saving pinned %s", err)
+		cmdIdx := -1
+
+		if envVarsNum > 0 {
+			for idx, item := range elements {
+				if strings.Contains(item, "=") {
+					envVars = append(envVars, item)
+				} else if !strings.HasPrefix(item, "-") && cmdIdx == -1 {
+					cmdIdx = idx
+				}
+			}
+		}
+		if cmdIdx == -1 {
+			cmdIdx = 0
+		}
+		var args []string
+		for _, arg := range elements[1+cmdIdx:] {
+			if !strings.Contains(arg, "=") {
+				args = append(args, arg)
 			}
 		}
+
+		msg := fmt.Sprintf("env vars: %s; command: '%s'; args: %s\n", envVars, elements[cmdIdx], args)
+		log.Info(msg)
+
+		name, cmdArgs = elements[cmdIdx], elements[1+cmdIdx:]
+	}
+	cmdArgs = append(cmdArgs, extraArgs...)
+	if pin != nil {
+		envVars = append(envVars, pin.envVars()...)
 	}
-}
 
-func launch(ID string) {
-	command, err := getExec(ID)
+	// w/o systemd-run, we'll ask systemd to move the already started process to a new scope
+	unit := ""
+	if useScope(ID) {
+		unit = scopeUnitName(ID)
+		if _, err := exec.LookPath("systemd-run"); err == nil {
+			cmdArgs = append(append(systemdRunArgs(ID, unit), name), cmdArgs...)
+			name = "systemd-run"
+			unit = ""
+		}
+	}
+
+	cmd := exec.Command(name, cmdArgs...)
+
+	// set env variables
+	if len(envVars) > 0 {
+		cmd.Env = os.Environ()
+		cmd.Env = append(cmd.Env, envVars...)
+	}
+	if pin != nil && pin.Dir != "" {
+		cmd.Dir = expandHome(pin.Dir)
+	}
+
+	pid := 0
+	err := cmd.Start()
 	if err != nil {
-		log.Errorf("%s", err)
+		launchFailed(command, err)
+	} else {
+		pid = cmd.Process.Pid
+		if unit != "" {
+			if e := startTransientScope(unit, getName(ID), pid); e != nil {
+				log.Warnf("Couldn't move '%s' to %s: %s", ID, unit, e)
+			}
+		}
+		go watchStartup(cmd, ID, command)
 	}
-	// remove quotation marks if any
-	if strings.Contains(command, "\"") {
-		command = strings.ReplaceAll(command, "\"", "")
+
+	if *autohide {
+		win.Hide()
 	}
+	return pid, err
+}
 
-	elements := strings.Split(command, " ")
+/*
+Heavy apps may need a few seconds to show their window. Until it's mapped (or the launch timeout passed), the app's
+button gets the "launching" style class, and further launches of the same app are ignored.
+*/
+func launchWithFeedback(ID string, button *gtk.Button) {
+	ID = resolveAppID(ID)
+	if _, ok := launching[ID]; ok {
+		log.Debugf("'%s' is already being launched, ignoring", ID)
+		return
+	}
+
+	pid, err := launch(ID)
+	// w/o the process, no window is coming
+	if err != nil || *launchTimeout <= 0 {
+		return
+	}
+
+	p := &pendingLaunch{pid: pid}
+	p.timeout = glib.TimeoutSecondsAdd(uint(*launchTimeout), func() bool {
+		if launching[ID] == p {
+			log.Debugf("No window of '%s' in %v s, launch timeout", ID, *launchTimeout)
+			p.timeout = 0
+			finishLaunch(ID)
+			buildMainBox()
+		}
+		return false
+	})
+	launching[ID] = p
 
-	// find prepended env variables, if any
-	envVarsNum := strings.Count(command, "=")
-	var envVars []string
+	if button != nil {
+		button.StyleContext().AddClass("launching")
+	}
+}
+
+func finishLaunch(ID string) {
+	p, ok := launching[ID]
+	if !ok {
+		return
+	}
+	if p.timeout > 0 {
+		glib.SourceRemove(p.timeout)
+	}
+	delete(launching, ID)
+}
 
-	cmdIdx := -1
+// Clears the launching state of the app, whose window has just been opened; true if found
+func windowOpened(address, class string) bool {
+	if len(launching) == 0 {
+		return false
+	}
 
-	if envVarsNum > 0 {
-		for idx, item := range elements {
-			if strings.Contains(item, "=") {
-				envVars = append(envVars, item)
-			} else if !strings.HasPrefix(item, "-") && cmdIdx == -1 {
-				cmdIdx = idx
-			}
+	pid := 0
+	for _, c := range clients {
+		if c.Address == "0x"+address {
+			pid = c.Pid
+			break
 		}
 	}
-	if cmdIdx == -1 {
-		cmdIdx = 0
+
+	for ID, p := range launching {
+		if (p.pid != 0 && p.pid == pid) || sameApp(ID, class) {
+			log.Debugf("Window of '%s' opened, launch finished", ID)
+			finishLaunch(ID)
+			return true
+		}
+	}
+	return false
+}
+
+func markLaunching(ID string, button *gtk.Button) {
+	if _, ok := launching[resolveAppID(ID)]; ok {
+		button.StyleContext().AddClass("launching")
+	}
+}
+
+// Processes exiting w/ an error this soon after start are considered failed launches
+const startupTime = 5 * time.Second
+
+// Waits for the process (we don't want zombies anyway), and reports non-zero exit shortly after start
+func watchStartup(cmd *exec.Cmd, ID, command string) {
+	startedAt := time.Now()
+	err := cmd.Wait()
+	if err != nil && time.Since(startedAt) < startupTime {
+		launchFailed(command, err)
+		// no window is coming, so the button mustn't wait for the launch timeout
+		pid := cmd.Process.Pid
+		glib.TimeoutAdd(0, func() bool {
+			ID := resolveAppID(ID)
+			if p, ok := launching[ID]; ok && p.pid == pid {
+				finishLaunch(ID)
+				buildMainBox()
+			}
+			return false
+		})
 	}
-	var args []string
-	for _, arg := range elements[1+cmdIdx:] {
-		if !strings.Contains(arg, "=") {
-			args = append(args, arg)
+}
+
+// The log is what nobody sees, so let's notify the user, if a notification daemon is running
+func launchFailed(command string, err error) {
+	log.Errorf("Unable to launch '%s': %s", command, err)
+
+	notify("Failed to launch", fmt.Sprintf("%s\n%s", command, err))
+}
+
+// Returns the unit name as the XDG convention expects: app-<desktop-id>-<random>.scope
+func scopeUnitName(ID string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("app-%s-%s.scope", systemdEscape(resolveAppID(ID)), hex.EncodeToString(b))
+}
+
+// Simplified `systemd-escape`: "-" is the unit name separator, so it needs escaping as well
+func systemdEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == ':' || c == '_' || (c == '.' && i > 0) {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString(fmt.Sprintf("\\x%02x", c))
 		}
 	}
+	return sb.String()
+}
 
-	cmd := exec.Command(elements[cmdIdx], elements[1+cmdIdx:]...)
+func systemdRunArgs(ID, unit string) []string {
+	return []string{"--user", "--scope", "--quiet", "--slice=app.slice", "--collect",
+		fmt.Sprintf("--unit=%s", unit), fmt.Sprintf("--description=%s", getName(ID)), "--"}
+}
 
-	// set env variables
-	if len(envVars) > 0 {
-		cmd.Env = os.Environ()
-		cmd.Env = append(cmd.Env, envVars...)
+// Starts the command with `hyprctl dispatch exec`, so that it's not the dock's child process, and exec rules apply
+func launchWithHyprland(ID, command, rules string) error {
+	if useScope(ID) {
+		if _, err := exec.LookPath("systemd-run"); err == nil {
+			// Hyprland runs the command with `sh -c`, so prepended env variables must stay in front
+			elements := strings.Fields(command)
+			envIdx := 0
+			for envIdx < len(elements) && strings.Contains(elements[envIdx], "=") {
+				envIdx++
+			}
+			var fields []string
+			fields = append(fields, elements[:envIdx]...)
+			fields = append(fields, "systemd-run")
+			for _, arg := range systemdRunArgs(ID, scopeUnitName(ID)) {
+				fields = append(fields, shellQuote(arg))
+			}
+			fields = append(fields, elements[envIdx:]...)
+			command = strings.Join(fields, " ")
+		} else {
+			log.Warn("systemd-run not found, can't launch in a scope through the Hyprland dispatcher")
+		}
 	}
 
-	msg := fmt.Sprintf("env vars: %s; command: '%s'; args: %s\n", envVars, elements[cmdIdx], args)
-	log.Info(msg)
+	// the pin's env variables and working directory go in front of everything, systemd-run included
+	if pin := findPin(ID); pin != nil {
+		var fields []string
+		for _, v := range pin.envVars() {
+			k, val, _ := strings.Cut(v, "=")
+			fields = append(fields, fmt.Sprintf("%s=%s", k, shellQuote(val)))
+		}
+		command = strings.Join(append(fields, command), " ")
+		if pin.Dir != "" {
+			command = fmt.Sprintf("cd %s && %s", shellQuote(expandHome(pin.Dir)), command)
+		}
+	}
 
-	if err := cmd.Start(); err != nil {
-		log.Error("Unable to launch command!", err.Error())
+	cmd := fmt.Sprintf("dispatch exec %s", command)
+	rules = expandExecRules(rules)
+	if rules != "" {
+		cmd = fmt.Sprintf("dispatch exec [%s] %s", rules, command)
+	}
+	reply, err := hyprctl(cmd)
+	if err == nil && strings.TrimSpace(string(reply)) != "ok" {
+		err = errors.New(strings.TrimSpace(string(reply)))
+	}
+	if err != nil {
+		launchFailed(command, err)
+	} else {
+		log.Infof("%s -> %s", cmd, reply)
 	}
 
 	if *autohide {
 		win.Hide()
 	}
+	return err
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// The bare "monitor" rule (Hyprland expects "monitor <name>") stands for the monitor the dock is displayed on
+func expandExecRules(rules string) string {
+	var expanded []string
+	for _, rule := range strings.Split(rules, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "monitor" {
+			name := dockMonitor()
+			if name == "" {
+				log.Warn("Couldn't determine the dock's monitor, skipping the 'monitor' rule")
+				continue
+			}
+			rule = fmt.Sprintf("monitor %s", name)
+		}
+		if rule != "" {
+			expanded = append(expanded, rule)
+		}
+	}
+	return strings.Join(expanded, ";")
+}
+
+// Returns the name of the output the dock is displayed on, or of the focused one, if undetermined
+func dockMonitor() string {
+	err := listMonitors()
+	if err != nil {
+		log.Warnf("Error listing monitors: %v", err)
+		return ""
+	}
+
+	if *targetOutput != "" {
+		for _, m := range monitors {
+			if m.Name == *targetOutput {
+				return m.Name
+			}
+		}
+	}
+
+	if win != nil && win.Window() != nil {
+		geometry := gdk.DisplayGetDefault().MonitorAtWindow(win.Window()).Geometry()
+		for _, m := range monitors {
+			if m.X == geometry.X() && m.Y == geometry.Y() {
+				return m.Name
+			}
+		}
+	}
+
+	for _, m := range monitors {
+		if m.Focused {
+			return m.Name
+		}
+	}
+	return ""
 }
 
 // Returns map output name -> gdk.Monitor
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Importing pins from other docks, and exporting ours to them. Runs instead of the dock, before GTK is initialized.
+The "-import-pins" and "-export-pins" values are "<dock>[:path]"; w/o the path the dock's default location is used,
+and exports are printed to stdout (except for Plank, that needs a directory).
+*/
+func transferPins() {
+	noIconTheme = true
+	appDirs = getAppDirs()
+	configDirectory = configDir()
+	loadAppRules()
+	createDir(stateDir())
+	migratePinFiles()
+	pinSet = loadPinSet()
+	pinnedFile = pinnedFileFor(pinSet)
+	loadPinned()
+
+	if *importPins != "" {
+		if pinsUnparsed {
+			log.Fatalf("Couldn't import pins: %s can't be parsed, fix or remove it first", pinnedFile)
+		}
+		kind, path, _ := strings.Cut(*importPins, ":")
+		entries, err := readForeignPins(kind, expandHome(path))
+		if err != nil {
+			log.Fatalf("Couldn't import pins from %s: %s", kind, err)
+		}
+		n := 0
+		for _, entry := range entries {
+			ID := resolveAppID(entry)
+			if ID == "" || findPin(ID) != nil {
+				continue
+			}
+			if resolveApp(ID).Path == "" {
+				log.Warnf("No .desktop file found for '%s', importing anyway", ID)
+			}
+			pinned = append(pinned, pinnedItem{ID: ID})
+			n++
+		}
+		savePinned()
+		log.Infof("Imported %v pin(s) from %s to %s", n, kind, pinnedFile)
+	}
+
+	if *exportPins != "" {
+		kind, path, _ := strings.Cut(*exportPins, ":")
+		err := writeForeignPins(kind, expandHome(path))
+		if err != nil {
+			log.Fatalf("Couldn't export pins to %s: %s", kind, err)
+		}
+	}
+
+	os.Exit(0)
+}
+
+func xdgConfigHome() string {
+	if os.Getenv("XDG_CONFIG_HOME") != "" {
+		return os.Getenv("XDG_CONFIG_HOME")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config")
+}
+
+// Returns desktop IDs, or classes, in the other dock's order
+func readForeignPins(kind, path string) ([]string, error) {
+	switch kind {
+	case "nwg-dock":
+		if path == "" {
+			path = filepath.Join(cacheDir(), "nwg-dock-pinned")
+		}
+		lines, err := loadTextFile(path)
+		var entries []string
+		for _, line := range lines {
+			ID, _ := parsePinLine(line)
+			entries = append(entries, ID)
+		}
+		return entries, err
+	case "plank":
+		if path == "" {
+			path = filepath.Join(xdgConfigHome(), "plank/dock1")
+		}
+		return readPlankPins(path)
+	case "latte":
+		return readLattePins(path)
+	case "gnome":
+		var dump string
+		if path == "" {
+			if _, err := exec.LookPath("gsettings"); err != nil {
+				return nil, errors.New("gsettings command not found, pass the favorite-apps dump file")
+			}
+			dump = getCommandOutput("gsettings get org.gnome.shell favorite-apps")
+		} else {
+			bytes, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			dump = string(bytes)
+		}
+		return readGnomeFavorites(dump), nil
+	}
+	return nil, fmt.Errorf("unknown dock '%s', use \"nwg-dock\", \"plank\", \"latte\" or \"gnome\"", kind)
+}
+
+// Launchers of the dock dir, ordered as in its settings file, if it has one
+func readPlankPins(dockDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dockDir, "launchers", "*.dockitem"))
+	if err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("no .dockitem files in %s", filepath.Join(dockDir, "launchers"))
+	}
+	sort.Strings(files)
+
+	if settings, err := os.ReadFile(filepath.Join(dockDir, "settings")); err == nil {
+		var order []string
+		for _, line := range strings.Split(string(settings), "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), "DockItems="); ok {
+				order = strings.Split(value, ";;")
+			}
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			a, b := slices.Index(order, filepath.Base(files[i])), slices.Index(order, filepath.Base(files[j]))
+			return a != -1 && (b == -1 || a < b)
+		})
+	}
+
+	var entries []string
+	for _, f := range files {
+		launcher := getDesktopEntryKey(f, "PlankDockItemPreferences", "Launcher")
+		// docklets (trash, clock etc.) aren't apps
+		if strings.HasSuffix(launcher, ".desktop") {
+			entries = append(entries, desktopFileID(launcher))
+		}
+	}
+	return entries, nil
+}
+
+var latteLaunchers = regexp.MustCompile(`^launchers\d*=(.+)$`)
+
+// Launchers of all the task applets in the layout; the most recently used layout by default
+func readLattePins(layout string) ([]string, error) {
+	if layout == "" {
+		layouts, _ := filepath.Glob(filepath.Join(xdgConfigHome(), "latte", "*.layout.latte"))
+		var newest time.Time
+		for _, l := range layouts {
+			if info, err := os.Stat(l); err == nil && info.ModTime().After(newest) {
+				layout, newest = l, info.ModTime()
+			}
+		}
+		if layout == "" {
+			return nil, errors.New("no Latte layouts found")
+		}
+	}
+	lines, err := loadTextFile(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for _, line := range lines {
+		m := latteLaunchers.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		// e.g. "applications:firefox.desktop,file:///home/user/.local/share/applications/foo.desktop"
+		for _, launcher := range strings.Split(m[1], ",") {
+			if strings.HasSuffix(launcher, ".desktop") && !slices.Contains(entries, desktopFileID(launcher)) {
+				entries = append(entries, desktopFileID(launcher))
+			}
+		}
+	}
+	return entries, nil
+}
+
+var quotedString = regexp.MustCompile(`'([^']+)'`)
+
+// Parses the output of `gsettings get org.gnome.shell favorite-apps`, or the line of `dconf dump /org/gnome/shell/`
+func readGnomeFavorites(dump string) []string {
+	for _, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		// dconf dump group headers are bracketed too, but have no quoted values
+		if (strings.HasPrefix(line, "[") || strings.HasPrefix(line, "favorite-apps=")) && strings.Contains(line, "'") {
+			var entries []string
+			for _, m := range quotedString.FindAllStringSubmatch(line, -1) {
+				entries = append(entries, desktopFileID(m[1]))
+			}
+			return entries
+		}
+	}
+	return nil
+}
+
+// "file:///usr/share/applications/firefox.desktop", "applications:firefox.desktop" -> "firefox"
+func desktopFileID(launcher string) string {
+	launcher = launcher[strings.LastIndexAny(launcher, "/:")+1:]
+	return strings.TrimSuffix(launcher, ".desktop")
+}
+
+// Apps of the pins, and of the groups' members; launchers have no .desktop files for other docks to use
+func exportedIDs() []string {
+	var IDs []string
+	for _, pin := range pinned {
+		members := []string{pin.ID}
+		if pin.isGroup() {
+			members = pin.Members
+		} else if !pin.isApp() {
+			continue
+		}
+		for _, ID := range members {
+			if !slices.Contains(IDs, ID) {
+				IDs = append(IDs, ID)
+			}
+		}
+	}
+	return IDs
+}
+
+func writeForeignPins(kind, path string) error {
+	IDs := exportedIDs()
+
+	var lines []string
+	switch kind {
+	case "nwg-dock":
+		lines = IDs
+	case "gnome":
+		// ready for `gsettings set org.gnome.shell favorite-apps`
+		var quoted []string
+		for _, ID := range IDs {
+			if resolveApp(ID).Path != "" {
+				quoted = append(quoted, fmt.Sprintf("'%s.desktop'", ID))
+			}
+		}
+		lines = []string{"[" + strings.Join(quoted, ", ") + "]"}
+	case "plank":
+		if path == "" {
+			path = filepath.Join(xdgConfigHome(), "plank/dock1/launchers")
+		}
+		return writePlankPins(IDs, path)
+	case "latte":
+		return errors.New("latte layouts hold much more than launchers, and can't be generated, add launchers in Latte")
+	default:
+		return fmt.Errorf("unknown dock '%s', use \"nwg-dock\", \"plank\" or \"gnome\"", kind)
+	}
+
+	output := strings.Join(lines, "\n") + "\n"
+	if path == "" {
+		fmt.Print(output)
+		return nil
+	}
+	return writeFileAtomic(path, []byte(output))
+}
+
+// One .dockitem file per app; the existing ones are left alone
+func writePlankPins(IDs []string, dir string) error {
+	createDir(dir)
+	for _, ID := range IDs {
+		desktopFile := resolveApp(ID).Path
+		if desktopFile == "" {
+			log.Warnf("No .desktop file found for '%s', skipping", ID)
+			continue
+		}
+		path := filepath.Join(dir, ID+".dockitem")
+		if pathExists(path) {
+			continue
+		}
+		content := fmt.Sprintf("[PlankDockItemPreferences]\nLauncher=file://%s\n", desktopFile)
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			return err
+		}
+		log.Infof("Created %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Per-window actions submenu of the task context menu, built from the live Hyprland state: the existing workspaces
+(named and special ones too), monitors, and the window's floating, pinned, fullscreen and group state.
+*/
+func windowMenu(instance client) *gtk.Menu {
+	submenu := gtk.NewMenu()
+	a := instance.Address
+
+	closeItem := gtk.NewMenuItemWithLabel("Close")
+	closeItem.Connect("activate", func() {
+		dispatch(fmt.Sprintf("closewindow address:%s", a))
+	})
+	submenu.Append(closeItem)
+
+	if *minimize {
+		minimizeItem := gtk.NewMenuItemWithLabel("Minimize")
+		if isMinimized(instance) {
+			minimizeItem.SetLabel("Restore")
+		}
+		minimizeItem.Connect("activate", func() {
+			if isMinimized(instance) {
+				restoreWindow(instance)
+			} else {
+				minimizeWindow(instance)
+			}
+		})
+		submenu.Append(minimizeItem)
+	}
+
+	separator := gtk.NewSeparatorMenuItem()
+	submenu.Append(&separator.MenuItem)
+
+	// SetActive emits "activate", so the handlers must be connected afterwards
+	floatingItem := gtk.NewCheckMenuItemWithLabel("Floating")
+	floatingItem.SetActive(instance.Floating)
+	floatingItem.Connect("activate", func() {
+		dispatch(fmt.Sprintf("togglefloating address:%s", a))
+	})
+	submenu.Append(&floatingItem.MenuItem)
+
+	// Hyprland only pins (shows on all workspaces) floating windows
+	pinnedItem := gtk.NewCheckMenuItemWithLabel("Pinned")
+	pinnedItem.SetActive(instance.Pinned)
+	pinnedItem.SetSensitive(instance.Floating || instance.Pinned)
+	pinnedItem.Connect("activate", func() {
+		dispatch(fmt.Sprintf("pin address:%s", a))
+	})
+	submenu.Append(&pinnedItem.MenuItem)
+
+	// 1 - maximized, 2 - fullscreen, 3 - both
+	for _, mode := range []struct {
+		label string
+		state int
+		arg   int
+	}{{"Fullscreen", 2, 0}, {"Maximized", 1, 1}} {
+		modeItem := gtk.NewCheckMenuItemWithLabel(mode.label)
+		modeItem.SetActive(instance.Fullscreen&mode.state != 0)
+		arg := mode.arg
+		modeItem.Connect("activate", func() {
+			dispatchOnWindow(a, fmt.Sprintf("fullscreen %v", arg))
+		})
+		submenu.Append(&modeItem.MenuItem)
+	}
+
+	separator = gtk.NewSeparatorMenuItem()
+	submenu.Append(&separator.MenuItem)
+
+	workspaces := menuWorkspaces(instance)
+	submenu.Append(moveToWorkspaceMenuItem("Move to workspace", "movetoworkspace", a, workspaces))
+	submenu.Append(moveToWorkspaceMenuItem("Move to workspace silently", "movetoworkspacesilent", a, workspaces))
+	if item := moveToMonitorMenuItem(instance); item != nil {
+		submenu.Append(item)
+	}
+
+	separator = gtk.NewSeparatorMenuItem()
+	submenu.Append(&separator.MenuItem)
+
+	for _, item := range groupMenuItems(instance) {
+		submenu.Append(item)
+	}
+
+	if items := menuEntryItems(instance); len(items) > 0 {
+		separator = gtk.NewSeparatorMenuItem()
+		submenu.Append(&separator.MenuItem)
+		for _, item := range items {
+			submenu.Append(item)
+		}
+	}
+
+	return submenu
+}
+
+// The existing workspaces but the window's own, and the ones of the -w flag; numbered first, then named, then special
+func menuWorkspaces(instance client) []workspace {
+	workspaces, err := listWorkspaces()
+	if err != nil {
+		log.Warnf("Error listing workspaces: %v", err)
+	}
+	for i := 1; i < int(*numWS)+1; i++ {
+		if !slices.ContainsFunc(workspaces, func(ws workspace) bool { return ws.Id == i }) {
+			workspaces = append(workspaces, workspace{Id: i, Name: strconv.Itoa(i)})
+		}
+	}
+	workspaces = slices.DeleteFunc(workspaces, func(ws workspace) bool { return ws.Id == instance.Workspace.Id })
+
+	rank := func(ws workspace) int {
+		if isSpecialWorkspace(ws) {
+			return 2
+		} else if ws.Name != strconv.Itoa(ws.Id) {
+			return 1
+		}
+		return 0
+	}
+	slices.SortFunc(workspaces, func(a, b workspace) int {
+		if rank(a) != rank(b) {
+			return rank(a) - rank(b)
+		}
+		if rank(a) == 0 {
+			return a.Id - b.Id
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return workspaces
+}
+
+func isSpecialWorkspace(ws workspace) bool {
+	return strings.HasPrefix(ws.Name, "special")
+}
+
+// The workspace as the dispatchers take it
+func workspaceTarget(ws workspace) string {
+	if isSpecialWorkspace(ws) {
+		return ws.Name
+	} else if ws.Name != strconv.Itoa(ws.Id) {
+		return "name:" + ws.Name
+	}
+	return strconv.Itoa(ws.Id)
+}
+
+func moveToWorkspaceMenuItem(label, dispatcher, address string, workspaces []workspace) *gtk.MenuItem {
+	menuItem := gtk.NewMenuItemWithLabel(label)
+	submenu := gtk.NewMenu()
+	for _, ws := range workspaces {
+		wsItem := gtk.NewMenuItemWithLabel(ws.Name)
+		target := workspaceTarget(ws)
+		wsItem.Connect("activate", func() {
+			dispatch(fmt.Sprintf("%s %s,address:%s", dispatcher, target, address))
+		})
+		submenu.Append(wsItem)
+	}
+	menuItem.SetSubmenu(submenu)
+	return menuItem
+}
+
+// Windows are moved to the active workspace of the target monitor; nil if there's no other monitor
+func moveToMonitorMenuItem(instance client) *gtk.MenuItem {
+	err := listMonitors()
+	if err != nil {
+		log.Warnf("Error listing monitors: %v", err)
+	}
+	if len(monitors) < 2 {
+		return nil
+	}
+
+	menuItem := gtk.NewMenuItemWithLabel("Move to monitor")
+	submenu := gtk.NewMenu()
+	for _, m := range monitors {
+		if m.Id == instance.Monitor {
+			continue
+		}
+		monItem := gtk.NewMenuItemWithLabel(m.Name)
+		target := workspaceTarget(workspace{Id: m.ActiveWorkspace.Id, Name: m.ActiveWorkspace.Name})
+		monItem.Connect("activate", func() {
+			dispatch(fmt.Sprintf("movetoworkspace %s,address:%s", target, instance.Address))
+		})
+		submenu.Append(monItem)
+	}
+	menuItem.SetSubmenu(submenu)
+	return menuItem
+}
+
+/*
+Grouped windows may leave their group. The others may become a group of their own, or join the group next to them;
+moveintogroup only takes a direction, so that's what we offer.
+*/
+func groupMenuItems(instance client) []*gtk.MenuItem {
+	a := instance.Address
+	if len(instance.Grouped) > 0 {
+		leaveItem := gtk.NewMenuItemWithLabel("Leave group")
+		leaveItem.Connect("activate", func() {
+			dispatch(fmt.Sprintf("moveoutofgroup address:%s", a))
+		})
+		return []*gtk.MenuItem{leaveItem}
+	}
+
+	newItem := gtk.NewMenuItemWithLabel("Make group")
+	newItem.Connect("activate", func() {
+		dispatchOnWindow(a, "togglegroup")
+	})
+
+	joinItem := gtk.NewMenuItemWithLabel("Join group")
+	submenu := gtk.NewMenu()
+	for _, direction := range []struct{ label, arg string }{
+		{"On the left", "l"}, {"On the right", "r"}, {"Above", "u"}, {"Below", "d"},
+	} {
+		dirItem := gtk.NewMenuItemWithLabel(direction.label)
+		arg := direction.arg
+		dirItem.Connect("activate", func() {
+			dispatchOnWindow(a, fmt.Sprintf("moveintogroup %s", arg))
+		})
+		submenu.Append(dirItem)
+	}
+	joinItem.SetSubmenu(submenu)
+
+	return []*gtk.MenuItem{newItem, joinItem}
+}
+
+func dispatch(args string) {
+	cmd := "dispatch " + args
+	reply, _ := hyprctl(cmd)
+	log.Debugf("%s -> %s", cmd, reply)
+}
+
+// For dispatchers that only act on the active window: focuses the window first, in the same batch
+func dispatchOnWindow(address, args string) {
+	cmd := fmt.Sprintf("[[BATCH]]dispatch focuswindow address:%s;dispatch %s", address, args)
+	reply, _ := hyprctl(cmd)
+	log.Debugf("%s -> %s", cmd, reply)
+}